@@ -198,3 +198,28 @@ cute loli, {anime style}, {high quality},  {1 girl}, {black hair}, {brown eyes},
 """{{content}}"""
 
 prompt=`
+
+const JSONFormat = `请严格按照JSON格式输出你的回答，不要输出JSON以外的任何内容，不要进行解释。
+{{- if .schema }}
+
+输出的JSON必须符合以下 JSON Schema：
+"""
+{{ .schema }}
+"""
+{{- else }}
+
+输出必须是一个JSON对象。
+{{- end }}`
+
+const JSONFormatRetry = `你上一次的输出没有通过JSON校验，错误如下：
+{{- range $index, $value := .errors }}
+- {{ $value }}
+{{- end }}
+
+请修正后重新输出，只输出JSON。`
+
+const Continue = `你的回答因长度限制被截断了，请从中断的位置继续输出。
+不要重复已经输出的内容，不要做任何解释，直接接着最后一个字符往下写。
+{{- if .fence }}
+当前正处于未闭合的代码块中，请直接继续输出代码，不要重新开始代码块。
+{{- end }}`
@@ -0,0 +1,549 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RomiChan/websocket"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/proxy"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+var cassetteAcceptRegexp = regexp.MustCompile(`(?i)(Sec-WebSocket-Accept:[ \t]*)[^\r\n]*`)
+
+// 一次上游交互：http 请求/响应（含 SSE），或 websocket 连接中服务端下发的原始字节
+type interaction struct {
+	Kind           string      `json:"kind"`
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	Header         http.Header `json:"header,omitempty"`
+	Body           string      `json:"body,omitempty"`
+	BodyHash       string      `json:"body_hash,omitempty"`
+	Status         int         `json:"status,omitempty"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	Response       string      `json:"response"`
+	Encoding       string      `json:"encoding,omitempty"`
+
+	used bool
+}
+
+// 录制文件，jsonl 格式，每行一次交互
+type cassetteFile struct {
+	mu           sync.Mutex
+	mode         string
+	proxies      string
+	file         *os.File
+	interactions []*interaction
+}
+
+// 录制/回放上游流量：接管 http.DefaultClient 与 websocket.DefaultDialer，
+// emit.ClientBuilder、emit.SocketBuilder 及各上游库在不设代理时都会经过这里。
+//
+//	cassette.mode: record 录制、replay 回放，留空关闭
+//	cassette.path: 录制文件，默认 cassettes/default.jsonl
+//
+// 开启后返回空代理，由录制器自行使用 proxies 访问上游。
+func CassetteInit(proxies string) string {
+	mode := pkg.Config.GetString("cassette.mode")
+	if mode != CassetteRecord && mode != CassetteReplay {
+		if mode != "" {
+			logrus.Warnf("cassette.mode: unknown mode '%s'", mode)
+		}
+		return proxies
+	}
+
+	name := pkg.Config.GetString("cassette.path")
+	if name == "" {
+		name = "cassettes/default.jsonl"
+	}
+
+	c, err := openCassette(mode, name, proxies)
+	if err != nil {
+		logrus.Errorf("cassette: %v", err)
+		os.Exit(1)
+	}
+
+	installCassette(c)
+	logrus.Infof("cassette %s: %s", mode, name)
+	return ""
+}
+
+func openCassette(mode, name, proxies string) (*cassetteFile, error) {
+	c := &cassetteFile{mode: mode, proxies: proxies}
+	if mode == CassetteRecord {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		c.file = file
+		return c, nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var it interaction
+		if err = json.Unmarshal(line, &it); err != nil {
+			return nil, err
+		}
+		c.interactions = append(c.interactions, &it)
+	}
+	return c, scanner.Err()
+}
+
+func installCassette(c *cassetteFile) {
+	http.DefaultClient.Transport = &cassetteTransport{c, c.transport()}
+	websocket.DefaultDialer = &websocket.Dialer{
+		HandshakeTimeout: 45 * time.Second,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return c.dial(ctx, "ws", addr)
+		},
+		NetDialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return c.dial(ctx, "wss", addr)
+		},
+	}
+}
+
+// 录制时访问上游使用的 transport，代理设置与 emit 一致
+func (c *cassetteFile) transport() http.RoundTripper {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+
+	if c.proxies == "" {
+		transport.Proxy = http.ProxyFromEnvironment
+		return transport
+	}
+
+	proxiesUrl, err := url.Parse(c.proxies)
+	if err != nil {
+		logrus.Errorf("cassette: %v", err)
+		return transport
+	}
+
+	switch proxiesUrl.Scheme {
+	case "http", "https":
+		transport.Proxy = http.ProxyURL(proxiesUrl)
+	case "socks5":
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return c.dialUpstream(ctx, addr)
+		}
+	}
+	return transport
+}
+
+func (c *cassetteFile) save(it *interaction) {
+	marshal, err := json.Marshal(it)
+	if err != nil {
+		logrus.Errorf("cassette: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err = c.file.Write(append(marshal, '\n')); err != nil {
+		logrus.Errorf("cassette: %v", err)
+	}
+}
+
+// 按顺序取出未使用的交互：优先 url 与请求体完全一致，其次忽略 query 与请求体
+func (c *cassetteFile) match(kind, method, u, bodyHash string) *interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, it := range c.interactions {
+		if !it.used && it.Kind == kind && it.Method == method && it.URL == u && it.BodyHash == bodyHash {
+			it.used = true
+			return it
+		}
+	}
+
+	path := cassettePath(u)
+	for _, it := range c.interactions {
+		if !it.used && it.Kind == kind && it.Method == method && cassettePath(it.URL) == path {
+			it.used = true
+			return it
+		}
+	}
+	return nil
+}
+
+type cassetteTransport struct {
+	cassette *cassetteFile
+	next     http.RoundTripper
+}
+
+func (t *cassetteTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body []byte
+	if request.Body != nil {
+		var err error
+		if body, err = io.ReadAll(request.Body); err != nil {
+			return nil, err
+		}
+		_ = request.Body.Close()
+		request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	u := request.URL.String()
+	if t.cassette.mode == CassetteReplay {
+		it := t.cassette.match("http", request.Method, u, cassetteHash(body))
+		if it == nil {
+			return nil, fmt.Errorf("cassette: no recorded exchange for %s %s", request.Method, u)
+		}
+
+		data, err := it.decode()
+		if err != nil {
+			return nil, err
+		}
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", it.Status, http.StatusText(it.Status)),
+			StatusCode:    it.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        it.ResponseHeader.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(data)),
+			ContentLength: int64(len(data)),
+			Request:       request,
+		}, nil
+	}
+
+	response, err := t.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response.Body = &recordBody{
+		ReadCloser: response.Body,
+		cassette:   t.cassette,
+		interaction: &interaction{
+			Kind:           "http",
+			Method:         request.Method,
+			URL:            u,
+			Header:         redactHeader(request.Header),
+			Body:           Redact(string(body)),
+			BodyHash:       cassetteHash(body),
+			Status:         response.StatusCode,
+			ResponseHeader: redactHeader(response.Header),
+		},
+	}
+	return response, nil
+}
+
+// 边读边录，读完或关闭时写入录制文件，客户端中途断开时保留已读到的部分
+type recordBody struct {
+	io.ReadCloser
+	cassette    *cassetteFile
+	interaction *interaction
+	buffer      bytes.Buffer
+	once        sync.Once
+}
+
+func (r *recordBody) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.buffer.Write(p[:n])
+	if err == io.EOF {
+		r.flush()
+	}
+	return
+}
+
+func (r *recordBody) Close() error {
+	r.flush()
+	return r.ReadCloser.Close()
+}
+
+func (r *recordBody) flush() {
+	r.once.Do(func() {
+		r.interaction.encode(r.buffer.Bytes())
+		r.cassette.save(r.interaction)
+	})
+}
+
+// websocket 连接：录制时在 TLS 之上记录明文，回放时返回模拟连接
+func (c *cassetteFile) dial(ctx context.Context, scheme, addr string) (net.Conn, error) {
+	if c.mode == CassetteReplay {
+		return &replayConn{cassette: c, scheme: scheme, ready: make(chan struct{}), closed: make(chan struct{})}, nil
+	}
+
+	conn, err := c.dialUpstream(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if scheme == "wss" {
+		host, _, _ := net.SplitHostPort(addr)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	return &recordConn{Conn: conn, cassette: c, scheme: scheme}, nil
+}
+
+// 直连或经 proxies 连接上游
+func (c *cassetteFile) dialUpstream(ctx context.Context, addr string) (net.Conn, error) {
+	if c.proxies == "" {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	proxiesUrl, err := url.Parse(c.proxies)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxiesUrl.Scheme == "socks5" {
+		dialer, e := proxy.SOCKS5("tcp", proxiesUrl.Host, nil, proxy.Direct)
+		if e != nil {
+			return nil, e
+		}
+		return dialer.Dial("tcp", addr)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", proxiesUrl.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", addr, addr)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, errors.New("cassette: proxy CONNECT " + response.Status)
+	}
+	return conn, nil
+}
+
+// 录制 websocket：客户端发出的帧带随机掩码无法比对，只记录握手请求与服务端下发的字节流
+type recordConn struct {
+	net.Conn
+	cassette *cassetteFile
+	scheme   string
+	mu       sync.Mutex
+	request  bytes.Buffer
+	response bytes.Buffer
+	once     sync.Once
+}
+
+func (r *recordConn) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	if !bytes.Contains(r.request.Bytes(), []byte("\r\n\r\n")) {
+		r.request.Write(p)
+	}
+	r.mu.Unlock()
+	return r.Conn.Write(p)
+}
+
+func (r *recordConn) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	r.mu.Lock()
+	r.response.Write(p[:n])
+	r.mu.Unlock()
+	return n, err
+}
+
+func (r *recordConn) Close() error {
+	r.once.Do(func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(r.request.Bytes())))
+		if err != nil {
+			logrus.Errorf("cassette: %v", err)
+			return
+		}
+
+		it := &interaction{
+			Kind:   "websocket",
+			Method: request.Method,
+			URL:    r.scheme + "://" + request.Host + request.URL.RequestURI(),
+			Header: redactHeader(request.Header),
+		}
+		it.encode(r.response.Bytes())
+		r.cassette.save(it)
+	})
+	return r.Conn.Close()
+}
+
+// 回放 websocket：收到握手请求后按 url 取出录制的字节流，并按新的 Sec-WebSocket-Key 重写握手响应
+type replayConn struct {
+	cassette *cassetteFile
+	scheme   string
+	mu       sync.Mutex
+	request  bytes.Buffer
+	reader   io.Reader
+	err      error
+	ready    chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (r *replayConn) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reader != nil || r.err != nil {
+		return len(p), nil
+	}
+
+	r.request.Write(p)
+	if !bytes.Contains(r.request.Bytes(), []byte("\r\n\r\n")) {
+		return len(p), nil
+	}
+
+	r.reader, r.err = r.handshake()
+	close(r.ready)
+	return len(p), nil
+}
+
+func (r *replayConn) handshake() (io.Reader, error) {
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(r.request.Bytes())))
+	if err != nil {
+		return nil, err
+	}
+
+	u := r.scheme + "://" + request.Host + request.URL.RequestURI()
+	it := r.cassette.match("websocket", request.Method, u, "")
+	if it == nil {
+		return nil, fmt.Errorf("cassette: no recorded exchange for %s %s", request.Method, u)
+	}
+
+	data, err := it.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	index := bytes.Index(data, []byte("\r\n\r\n"))
+	if index < 0 {
+		return bytes.NewReader(data), nil
+	}
+
+	hash := sha1.Sum([]byte(request.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	accept := base64.StdEncoding.EncodeToString(hash[:])
+	header := cassetteAcceptRegexp.ReplaceAll(data[:index], []byte("${1}"+accept))
+	return io.MultiReader(bytes.NewReader(header), bytes.NewReader(data[index:])), nil
+}
+
+func (r *replayConn) Read(p []byte) (int, error) {
+	select {
+	case <-r.ready:
+	case <-r.closed:
+		return 0, net.ErrClosed
+	}
+
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.reader.Read(p)
+}
+
+func (r *replayConn) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return nil
+}
+
+func (r *replayConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (r *replayConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (r *replayConn) SetDeadline(time.Time) error      { return nil }
+func (r *replayConn) SetReadDeadline(time.Time) error  { return nil }
+func (r *replayConn) SetWriteDeadline(time.Time) error { return nil }
+
+// 文本原样保存便于阅读，二进制内容使用 base64
+func (it *interaction) encode(data []byte) {
+	if utf8.Valid(data) {
+		it.Response = string(data)
+		return
+	}
+	it.Response = base64.StdEncoding.EncodeToString(data)
+	it.Encoding = "base64"
+}
+
+func (it *interaction) decode() ([]byte, error) {
+	if it.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(it.Response)
+	}
+	return []byte(it.Response), nil
+}
+
+func cassetteHash(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
+}
+
+func cassettePath(u string) string {
+	if index := strings.IndexByte(u, '?'); index >= 0 {
+		return u[:index]
+	}
+	return u
+}
+
+func redactHeader(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+
+	result := make(http.Header, len(header))
+	for key, values := range header {
+		for _, value := range values {
+			line := Redact(key + ": " + value)
+			result[key] = append(result[key], strings.TrimPrefix(line, key+": "))
+		}
+	}
+	return result
+}
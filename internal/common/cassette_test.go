@@ -0,0 +1,90 @@
+package common
+
+import (
+	"github.com/RomiChan/websocket"
+	"github.com/bincooo/emit.io"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassette(t *testing.T) {
+	transport, dialer := http.DefaultClient.Transport, websocket.DefaultDialer
+	defer func() {
+		http.DefaultClient.Transport, websocket.DefaultDialer = transport, dialer
+	}()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws" {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"msg":"process_completed"}`))
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			_ = conn.Close()
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: hello\n\ndata: [DONE]\n\n"))
+	}))
+
+	name := filepath.Join(t.TempDir(), "test.jsonl")
+	exchange := func() (string, string) {
+		response, err := emit.ClientBuilder().
+			POST(server.URL+"/chat?t=1").
+			Header("Cookie", "sessionid=secret").
+			Bytes([]byte(`{"q":"hi"}`)).
+			DoS(http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+
+		conn, err := emit.SocketBuilder().
+			URL("ws" + strings.TrimPrefix(server.URL, "http") + "/ws").
+			DoS(http.StatusSwitchingProtocols)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data), string(message)
+	}
+
+	c, err := openCassette(CassetteRecord, name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	installCassette(c)
+	body, message := exchange()
+	_ = c.file.Close()
+
+	// 回放时上游已不可用
+	server.Close()
+	if c, err = openCassette(CassetteReplay, name, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.interactions) != 2 {
+		t.Fatalf("recorded %d interactions, want 2", len(c.interactions))
+	}
+	if strings.Contains(c.interactions[0].Header.Get("Cookie"), "secret") {
+		t.Fatal("cookie is not redacted")
+	}
+
+	installCassette(c)
+	replayBody, replayMessage := exchange()
+	if replayBody != body || replayMessage != message {
+		t.Fatalf("replay mismatch: %q %q, want %q %q", replayBody, replayMessage, body, message)
+	}
+}
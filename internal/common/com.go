@@ -6,6 +6,7 @@ import (
 )
 
 func Init() {
+	logInit()
 	fileInit()
 	clashInit()
 }
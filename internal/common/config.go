@@ -0,0 +1,38 @@
+package common
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"sync"
+)
+
+// 从配置解析的值，随 pkg.Config 实例缓存，配置替换后重新解析
+type ConfigValue[T any] struct {
+	mu     sync.Mutex
+	config *viper.Viper
+	value  T
+	parse  func(config *viper.Viper) (T, error)
+}
+
+func NewConfigValue[T any](parse func(config *viper.Viper) (T, error)) *ConfigValue[T] {
+	return &ConfigValue[T]{parse: parse}
+}
+
+// 返回解析结果，解析失败时记录日志并返回零值
+//
+//	ctx: 用于记录日志，可为 nil
+func (c *ConfigValue[T]) Get(ctx *gin.Context) T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.config != pkg.Config {
+		value, err := c.parse(pkg.Config)
+		if err != nil {
+			Logger(ctx).Errorf("config: %v", err)
+		}
+		c.config = pkg.Config
+		c.value = value
+	}
+	return c.value
+}
@@ -0,0 +1,26 @@
+package common
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/spf13/viper"
+	"testing"
+)
+
+func TestConfigValue(t *testing.T) {
+	count := 0
+	value := NewConfigValue(func(config *viper.Viper) (string, error) {
+		count++
+		return config.GetString("name"), nil
+	})
+
+	testutil.Config(t).Set("name", "first")
+	if value.Get(nil) != "first" || value.Get(nil) != "first" || count != 1 {
+		t.Fatalf("expected a single parse, got %d", count)
+	}
+
+	// 配置替换后重新解析
+	testutil.Config(t).Set("name", "second")
+	if value.Get(nil) != "second" || count != 2 {
+		t.Fatalf("expected a parse after the config changed, got %d", count)
+	}
+}
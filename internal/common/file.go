@@ -142,3 +142,43 @@ func Download(proxies, url, suffix string) (file string, err error) {
 
 	return tempFile.Name(), nil
 }
+
+// 读取消息中的图片，支持 data url 与 http(s) 链接，返回 mime 类型与 base64 内容
+func LoadImage(ctx context.Context, proxies, url string) (mime, data string, err error) {
+	if strings.HasPrefix(url, "data:") {
+		index := strings.Index(url, ",")
+		if index < 0 {
+			return "", "", errors.New("invalid image data url")
+		}
+		mime = strings.TrimSuffix(strings.TrimPrefix(url[:index], "data:"), ";base64")
+		return mime, url[index+1:], nil
+	}
+
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "", "", errors.New("unsupported image url: " + url)
+	}
+
+	response, err := emit.ClientBuilder().
+		Proxies(proxies).
+		Context(ctx).
+		GET(url).
+		DoS(http.StatusOK)
+	if err != nil {
+		return "", "", err
+	}
+	defer response.Body.Close()
+
+	dec, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	mime = response.Header.Get("Content-Type")
+	if index := strings.Index(mime, ";"); index >= 0 {
+		mime = mime[:index]
+	}
+	if !strings.HasPrefix(mime, "image/") {
+		mime = http.DetectContentType(dec)
+	}
+	return mime, base64.StdEncoding.EncodeToString(dec), nil
+}
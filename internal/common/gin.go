@@ -29,6 +29,15 @@ func GetGinCompletionUsage(ctx *gin.Context) map[string]int {
 	return nil
 }
 
+func GetGinAnnotations(ctx *gin.Context) (values []pkg.Keyv[interface{}]) {
+	values, _ = GetGinValues[pkg.Keyv[interface{}]](ctx, vars.GinAnnotations)
+	return
+}
+
+func GetGinRequestId(ctx *gin.Context) string {
+	return ctx.GetString(vars.GinRequestId)
+}
+
 func GetGinValue[T any](ctx *gin.Context, key string) (t T, ok bool) {
 	value, exists := ctx.Get(key)
 	if !exists {
@@ -0,0 +1,157 @@
+package common
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+const modulePrefix = "github.com/bincooo/chatgpt-adapter/v2/"
+
+var (
+	// 需要脱敏的内容：请求头、cookie 字段、各类 key
+	redactions = []struct {
+		regexp  *regexp.Regexp
+		replace string
+	}{
+		{regexp.MustCompile(`(?i)((?:authorization|x-api-key|cookie|set-cookie)\s*[:=]\s*)("?)[^\r\n"]+`), "${1}${2}***"},
+		{regexp.MustCompile(`(?i)\b((?:msToken|sessionid|sessionKey|_U|sign|auth|token|key|[\w-]*SID|__Secure-[\w-]+)=)[^;&\s"]+`), "${1}***"},
+		{regexp.MustCompile(`(?i)("(?:sign|auth|token|cookie|msToken|sessionKey|key)"\s*:\s*)"[^"]*"`), `${1}"***"`},
+		{regexp.MustCompile(`(?i)\bBearer\s+[\w.~+/=-]+`), "Bearer ***"},
+		{regexp.MustCompile(`\bAIzaSy[\w-]+`), "AIzaSy***"},
+		{regexp.MustCompile(`\bsk-[\w-]{8,}`), "sk-***"},
+	}
+)
+
+// 日志格式化：按模块过滤级别，并对所有输出做脱敏
+type logFormatter struct {
+	formatter logrus.Formatter
+	level     logrus.Level
+	modules   map[string]logrus.Level
+}
+
+func logInit() {
+	level, err := logrus.ParseLevel(pkg.Config.GetString("log.level"))
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+
+	var formatter logrus.Formatter = &logrus.TextFormatter{FullTimestamp: true}
+	if pkg.Config.GetString("log.format") == "json" {
+		formatter = &logrus.JSONFormatter{}
+	}
+
+	// 日志器的级别取最详细的一个，再由 logFormatter 按模块过滤
+	maxLevel := level
+	modules := make(map[string]logrus.Level)
+	for module, value := range pkg.Config.GetStringMapString("log.modules") {
+		l, e := logrus.ParseLevel(value)
+		if e != nil {
+			logrus.Warnf("log.modules.%s: %v", module, e)
+			continue
+		}
+		modules[module] = l
+		if l > maxLevel {
+			maxLevel = l
+		}
+	}
+
+	logrus.SetOutput(os.Stdout)
+	logrus.SetLevel(maxLevel)
+	logrus.SetReportCaller(true)
+	logrus.SetFormatter(&logFormatter{formatter, level, modules})
+}
+
+func (f *logFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	module, ok := entry.Data["module"].(string)
+	if !ok {
+		module = callerModule(entry.Caller)
+	}
+
+	level, ok := f.modules[module]
+	if !ok {
+		level = f.level
+	}
+	if entry.Level > level {
+		return nil, nil
+	}
+
+	// 模块名代替调用位置
+	entry.Caller = nil
+	if module != "" {
+		entry.Data["module"] = module
+	}
+
+	entry.Message = Redact(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = Redact(v)
+		case error:
+			entry.Data[key] = Redact(v.Error())
+		}
+	}
+	return f.formatter.Format(entry)
+}
+
+// 调用方所在的模块，如 bing、lmsys、middle、handler
+func callerModule(frame *runtime.Frame) string {
+	if frame == nil {
+		return ""
+	}
+	return moduleOf(frame.Function)
+}
+
+func moduleOf(function string) string {
+	function = strings.TrimPrefix(function, modulePrefix)
+	if index := strings.LastIndex(function, "/"); index >= 0 {
+		function = function[index+1:]
+	}
+
+	if strings.HasPrefix(function, "gin.handler.") {
+		return "handler"
+	}
+
+	if index := strings.Index(function, "."); index >= 0 {
+		function = function[:index]
+	}
+	return function
+}
+
+// 对文本中的凭证脱敏
+func Redact(str string) string {
+	for _, r := range redactions {
+		str = r.regexp.ReplaceAllString(str, r.replace)
+	}
+	return str
+}
+
+// 携带 request_id 的日志，ctx 为 nil 时使用全局日志
+func Logger(ctx *gin.Context) *logrus.Entry {
+	if ctx == nil {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	if id := GetGinRequestId(ctx); id != "" {
+		return logrus.WithField("request_id", id)
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// 打印上游的原始输出，只在开启 <debug/> 标记或 debug 级别时可见
+func LogRaw(ctx *gin.Context, raw string) {
+	module := ""
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		module = moduleOf(runtime.FuncForPC(pc).Name())
+	}
+
+	entry := Logger(ctx).WithField("module", module)
+	if ctx.GetBool("debug") {
+		entry.Infof("----- raw -----\n %s", raw)
+		return
+	}
+	entry.Debugf("----- raw -----\n %s", raw)
+}
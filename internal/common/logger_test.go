@@ -0,0 +1,39 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	str := Redact("POST /v1/chat/completions HTTP/1.1\r\n" +
+		"Authorization: Bearer sk-1234567890abcdef\r\n" +
+		"X-Api-Key: xxx-yyy\r\n\r\n" +
+		`msToken=abc123; sessionid=def456; lang=zh` + "\n" +
+		`{"sign": "s1", "auth": "a1", "model": "coze"}` + "\n" +
+		`https://generativelanguage.googleapis.com/v1beta/models/gemini:streamGenerateContent?key=AIzaSyA1b2C3`)
+	t.Log(str)
+
+	for _, secret := range []string{"sk-1234567890abcdef", "xxx-yyy", "abc123", "def456", "s1", "a1", "AIzaSyA1b2C3"} {
+		if strings.Contains(str, secret) {
+			t.Fatalf("secret '%s' is not redacted", secret)
+		}
+	}
+
+	if !strings.Contains(str, "lang=zh") || !strings.Contains(str, `"model": "coze"`) {
+		t.Fatalf("unexpected redaction: %s", str)
+	}
+}
+
+func TestModuleOf(t *testing.T) {
+	for function, module := range map[string]string{
+		"github.com/bincooo/chatgpt-adapter/v2/internal/middle/bing.waitResponse":          "bing",
+		"github.com/bincooo/chatgpt-adapter/v2/internal/middle.ErrResponse":                "middle",
+		"github.com/bincooo/chatgpt-adapter/v2/internal/gin.handler.completions.func1":     "handler",
+		"github.com/bincooo/chatgpt-adapter/v2/internal/middle/lmsys.partTwo.func1.gowrap": "lmsys",
+	} {
+		if value := moduleOf(function); value != module {
+			t.Fatalf("moduleOf(%s) = %s, want %s", function, value, module)
+		}
+	}
+}
@@ -419,7 +419,7 @@ func XmlFlags(ctx *gin.Context, req *pkg.ChatCompletion) []pkg.Matcher {
 				if idx < pos && message["role"] != "system" {
 					replace, err := c.Replace(message.GetString("content"), value, -1, -1)
 					if err != nil {
-						logrus.Warn("compile failed: "+cmp, err)
+						Logger(ctx).Warn("compile failed: "+cmp, err)
 						continue
 					}
 					message["content"] = replace
@@ -472,7 +472,7 @@ func XmlFlags(ctx *gin.Context, req *pkg.ChatCompletion) []pkg.Matcher {
 			}
 			var baseMessages []pkg.Keyv[interface{}]
 			if err := json.Unmarshal([]byte(content), &baseMessages); err != nil {
-				logrus.Error("histories flags handle failed: ", err)
+				Logger(ctx).Error("histories flags handle failed: ", err)
 				continue
 			}
 
@@ -543,6 +543,11 @@ func xmlFlagsToContents(ctx *gin.Context, messages []pkg.Keyv[interface{}]) (han
 			"notebook", // notebook模式
 			"histories",
 			"tool",
+			"reasoning",
+			"mock",      // mock 模型的脚本参数
+			"safety",    // gemini 的安全级别
+			"citations", // bing 引用来源的处理方式
+			"document",  // cohere 的 RAG 文档
 		})
 	)
 
@@ -656,6 +661,55 @@ func xmlFlagsToContents(ctx *gin.Context, messages []pkg.Keyv[interface{}]) (han
 				continue
 			}
 
+			// 思考块处理模式: inline、strip、separate
+			if node.t == XML_TYPE_X && node.tag == "reasoning" {
+				if e, ok := node.attr["mode"]; ok {
+					if o, k := e.(string); k {
+						ctx.Set("reasoning", o)
+					}
+				}
+				clean(content[node.index:node.end])
+				continue
+			}
+
+			// mock 模型的脚本参数，覆盖 config.yaml 中的 mock 配置
+			if node.t == XML_TYPE_X && node.tag == "mock" {
+				ctx.Set("mock", pkg.Keyv[interface{}](node.attr))
+				clean(content[node.index:node.end])
+				continue
+			}
+
+			// bing 引用来源的处理方式: strip、annotations、footnotes
+			if node.t == XML_TYPE_X && node.tag == "citations" {
+				if e, ok := node.attr["mode"]; ok {
+					if o, k := e.(string); k {
+						ctx.Set("citations", o)
+					}
+				}
+				clean(content[node.index:node.end])
+				continue
+			}
+
+			// cohere 的 RAG 文档，属性作为文档字段，标签体作为 snippet
+			if node.t == XML_TYPE_X && node.tag == "document" {
+				doc := pkg.Keyv[interface{}](node.attr)
+				if str := strings.TrimSpace(node.content); str != "" {
+					doc["snippet"] = str
+				}
+				value, _ := ctx.Get("documents")
+				docs, _ := value.([]pkg.Keyv[interface{}])
+				ctx.Set("documents", append(docs, doc))
+				clean(content[node.index:node.end])
+				continue
+			}
+
+			// gemini 的安全级别，覆盖 config.yaml 中的 gemini.safety
+			if node.t == XML_TYPE_X && node.tag == "safety" {
+				ctx.Set("safety", pkg.Keyv[interface{}](node.attr))
+				clean(content[node.index:node.end])
+				continue
+			}
+
 			// debug 模式
 			if node.t == XML_TYPE_X && node.tag == "debug" {
 				ctx.Set("debug", true)
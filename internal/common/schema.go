@@ -0,0 +1,280 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var fenced = regexp.MustCompile("(?s)```(?:json|JSON)?\\s*\n(.*?)```")
+
+// 从AI的回复中提取JSON，依次尝试：全文、markdown代码块、第一个完整的 {} 或 [] 结构
+func ExtractJSON(content string) (string, interface{}, error) {
+	var value interface{}
+	content = strings.TrimSpace(content)
+	if err := json.Unmarshal([]byte(content), &value); err == nil {
+		return content, value, nil
+	}
+
+	for _, matched := range fenced.FindAllStringSubmatch(content, -1) {
+		str := strings.TrimSpace(matched[1])
+		if err := json.Unmarshal([]byte(str), &value); err == nil {
+			return str, value, nil
+		}
+	}
+
+	for index := 0; index < len(content); index++ {
+		if content[index] != '{' && content[index] != '[' {
+			continue
+		}
+
+		end := closeIndex(content, index)
+		if end < 0 {
+			continue
+		}
+
+		str := content[index : end+1]
+		if err := json.Unmarshal([]byte(str), &value); err == nil {
+			return str, value, nil
+		}
+	}
+
+	return "", nil, errors.New("no valid JSON found in output")
+}
+
+// 查找与 index 位置的括号闭合的下标，没有则-1
+func closeIndex(content string, index int) int {
+	var (
+		depth  = 0
+		quoted = false
+		escape = false
+	)
+
+	for i := index; i < len(content); i++ {
+		ch := content[i]
+		if quoted {
+			if escape {
+				escape = false
+			} else if ch == '\\' {
+				escape = true
+			} else if ch == '"' {
+				quoted = false
+			}
+			continue
+		}
+
+		switch ch {
+		case '"':
+			quoted = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// JSON Schema 校验的简单实现，返回所有不符合的描述，为空则通过
+//
+//	支持的关键字：type、properties、required、additionalProperties、items、enum、const、
+//	anyOf、oneOf、allOf、minItems、maxItems、minLength、maxLength、minimum、maximum、
+//	$ref（#/$defs/xxx、#/definitions/xxx）
+func ValidateSchema(schema map[string]interface{}, value interface{}) []string {
+	return validateSchema(schema, schema, value, "$")
+}
+
+func validateSchema(root, schema map[string]interface{}, value interface{}, path string) (errs []string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		sub := resolveRef(root, ref)
+		if sub == nil {
+			return []string{fmt.Sprintf("%s: unresolved $ref '%s'", path, ref)}
+		}
+		return validateSchema(root, sub, value, path)
+	}
+
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch v := t.(type) {
+		case string:
+			types = []string{v}
+		case []interface{}:
+			for _, it := range v {
+				if str, o := it.(string); o {
+					types = append(types, str)
+				}
+			}
+		}
+
+		if !ContainFor(types, func(item string) bool { return isType(item, value) }) {
+			return []string{fmt.Sprintf("%s: expected type %s, got %s", path, strings.Join(types, "|"), typeOf(value))}
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		if !ContainFor(enum, func(item interface{}) bool { return reflect.DeepEqual(item, value) }) {
+			errs = append(errs, fmt.Sprintf("%s: value is not one of %v", path, enum))
+		}
+	}
+
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		errs = append(errs, fmt.Sprintf("%s: value must be %v", path, c))
+	}
+
+	for _, sub := range schemaSlice(schema["allOf"]) {
+		errs = append(errs, validateSchema(root, sub, value, path)...)
+	}
+
+	if anyOf := schemaSlice(schema["anyOf"]); len(anyOf) > 0 {
+		matched := false
+		for _, sub := range anyOf {
+			if len(validateSchema(root, sub, value, path)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, fmt.Sprintf("%s: value does not match any schema of anyOf", path))
+		}
+	}
+
+	if oneOf := schemaSlice(schema["oneOf"]); len(oneOf) > 0 {
+		count := 0
+		for _, sub := range oneOf {
+			if len(validateSchema(root, sub, value, path)) == 0 {
+				count++
+			}
+		}
+		if count != 1 {
+			errs = append(errs, fmt.Sprintf("%s: value must match exactly one schema of oneOf, matched %d", path, count))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, it := range required {
+				key, _ := it.(string)
+				if _, o := v[key]; !o {
+					errs = append(errs, fmt.Sprintf("%s: missing required property '%s'", path, key))
+				}
+			}
+		}
+
+		for key, item := range v {
+			if sub, ok := properties[key].(map[string]interface{}); ok {
+				errs = append(errs, validateSchema(root, sub, item, path+"."+key)...)
+				continue
+			}
+
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					errs = append(errs, fmt.Sprintf("%s: additional property '%s' is not allowed", path, key))
+				}
+			case map[string]interface{}:
+				errs = append(errs, validateSchema(root, additional, item, path+"."+key)...)
+			}
+		}
+
+	case []interface{}:
+		if minItems, ok := schema["minItems"].(float64); ok && float64(len(v)) < minItems {
+			errs = append(errs, fmt.Sprintf("%s: expected at least %v items", path, minItems))
+		}
+		if maxItems, ok := schema["maxItems"].(float64); ok && float64(len(v)) > maxItems {
+			errs = append(errs, fmt.Sprintf("%s: expected at most %v items", path, maxItems))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for idx, item := range v {
+				errs = append(errs, validateSchema(root, items, item, fmt.Sprintf("%s[%d]", path, idx))...)
+			}
+		}
+
+	case string:
+		length := float64(len([]rune(v)))
+		if minLength, ok := schema["minLength"].(float64); ok && length < minLength {
+			errs = append(errs, fmt.Sprintf("%s: expected at least %v characters", path, minLength))
+		}
+		if maxLength, ok := schema["maxLength"].(float64); ok && length > maxLength {
+			errs = append(errs, fmt.Sprintf("%s: expected at most %v characters", path, maxLength))
+		}
+
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && v < minimum {
+			errs = append(errs, fmt.Sprintf("%s: expected >= %v", path, minimum))
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && v > maximum {
+			errs = append(errs, fmt.Sprintf("%s: expected <= %v", path, maximum))
+		}
+	}
+
+	return
+}
+
+func resolveRef(root map[string]interface{}, ref string) map[string]interface{} {
+	if ref == "#" {
+		return root
+	}
+
+	var value interface{} = root
+	for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = obj[key]
+	}
+
+	obj, _ := value.(map[string]interface{})
+	return obj
+}
+
+func schemaSlice(value interface{}) (slice []map[string]interface{}) {
+	values, _ := value.([]interface{})
+	for _, it := range values {
+		if obj, ok := it.(map[string]interface{}); ok {
+			slice = append(slice, obj)
+		}
+	}
+	return
+}
+
+func isType(t string, value interface{}) bool {
+	switch t {
+	case "integer":
+		v, ok := value.(float64)
+		return ok && v == math.Trunc(v)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return typeOf(value) == t
+	}
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
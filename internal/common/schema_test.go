@@ -0,0 +1,54 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	content := "好的，结果如下：\n```json\n{\"name\": \"pig\", \"tags\": [\"a\", \"}\"]}\n```\n希望对你有帮助"
+	str, value, err := ExtractJSON(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(str)
+
+	if value.(map[string]interface{})["name"] != "pig" {
+		t.Fatalf("unexpected value: %v", value)
+	}
+
+	str, _, err = ExtractJSON(`结果是 {"age": 3, "text": "{}"} 。`)
+	if err != nil || str != `{"age": 3, "text": "{}"}` {
+		t.Fatalf("unexpected result: %s, %v", str, err)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	var schema map[string]interface{}
+	_ = json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"pet": {"$ref": "#/$defs/pet"}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"$defs": {
+			"pet": {"type": "string", "enum": ["cat", "dog"]}
+		}
+	}`), &schema)
+
+	var value interface{}
+	_ = json.Unmarshal([]byte(`{"name": "tom", "age": 3, "pet": "cat"}`), &value)
+	if errs := ValidateSchema(schema, value); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	_ = json.Unmarshal([]byte(`{"name": "", "age": 1.5, "pet": "pig", "x": 1}`), &value)
+	errs := ValidateSchema(schema, value)
+	if len(errs) != 4 {
+		t.Fatalf("expected 4 errors, got: %v", errs)
+	}
+	t.Log(errs)
+}
@@ -2,18 +2,25 @@ package handler
 
 import (
 	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
+var requestIdRegexp = regexp.MustCompile(`^[\w.:-]{1,128}$`)
+
 func Bind(port int, version, proxies string) {
+	proxies = common.CassetteInit(proxies)
 	gin.SetMode(gin.ReleaseMode)
 	route := gin.Default()
 
@@ -27,6 +34,7 @@ func Bind(port int, version, proxies string) {
 
 	route.GET("/", welcome(version))
 	route.POST("/v1/chat/completions", completions)
+	route.GET("/v1/chat/ws", completionsWs)
 	route.POST("/v1/object/completions", completions)
 	route.POST("/proxies/v1/chat/completions", completions)
 	route.POST("v1/images/generations", generations)
@@ -34,6 +42,7 @@ func Bind(port int, version, proxies string) {
 	route.POST("proxies/v1/images/generations", generations)
 	route.GET("/proxies/v1/models", models)
 	route.GET("/v1/models", models)
+	route.GET("/metrics", metrics)
 	route.Static("/file/tmp/", "tmp")
 
 	addr := ":" + strconv.Itoa(port)
@@ -79,26 +88,45 @@ func crosHandler(context *gin.Context) {
 		return
 	}
 
-	uid := uuid.NewString()
+	uid := context.GetHeader("X-Request-Id")
+	if !requestIdRegexp.MatchString(uid) {
+		uid = uuid.NewString()
+	}
+	context.Set(vars.GinRequestId, uid)
+	context.Header("X-Request-Id", uid)
+
 	// 请求打印
-	data, err := httputil.DumpRequest(context.Request, false)
-	if err != nil {
-		logrus.Error(err)
-	} else {
-		fmt.Printf("\n\n\n\n------ Start request %s  ---------\n%s\n", uid, data)
+	start := time.Now()
+	logger := common.Logger(context)
+	logger.WithFields(logrus.Fields{
+		"method": method,
+		"path":   context.Request.URL.Path,
+		"client": context.ClientIP(),
+	}).Info("start request")
+
+	if logrus.IsLevelEnabled(logrus.DebugLevel) {
+		data, err := httputil.DumpRequest(context.Request, false)
+		if err != nil {
+			logger.Error(err)
+		} else {
+			logger.Debugf("request header: \n%s", data)
+		}
 	}
 
 	//处理请求
 	context.Next()
 
 	// 结束处理
-	fmt.Printf("------ End request %s  ---------\n", uid)
+	logger.WithFields(logrus.Fields{
+		"status":  context.Writer.Status(),
+		"latency": time.Since(start).String(),
+	}).Info("end request")
 }
 
 func panicHandler(ctx *gin.Context) {
 	defer func() {
 		if r := recover(); r != nil {
-			logrus.Errorf("response error: %v", r)
+			common.Logger(ctx).Errorf("response error: %v", r)
 			middle.ErrResponse(ctx, -1, fmt.Sprintf("%v", r))
 		}
 	}()
@@ -122,3 +150,7 @@ func models(ctx *gin.Context) {
 		"data":   GlobalExtension.Models(),
 	})
 }
+
+func metrics(ctx *gin.Context) {
+	ctx.String(http.StatusOK, middle.Metrics())
+}
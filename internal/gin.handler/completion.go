@@ -5,19 +5,23 @@ import (
 	"fmt"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/anthropic"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/azure"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/bing"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/claude"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/cohere"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/coze"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/gemini"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/lmsys"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/mock"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/ollama"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/openai"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/playground"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/sd"
 	v1 "github.com/bincooo/chatgpt-adapter/v2/internal/middle/v1"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -28,12 +32,17 @@ var (
 
 func InitExtensions() {
 	GlobalExtension.Extensions = []middle.Adapter{
+		anthropic.Adapter,
+		azure.Adapter,
 		bing.Adapter,
 		claude.Adapter,
 		coh.Adapter,
 		coze.Adapter,
 		gemini.Adapter,
 		lmsys.Adapter,
+		mock.Adapter,
+		ollama.Adapter,
+		openai.Adapter,
 		pg.Adapter,
 		sd.Adapter,
 		v1.Adapter,
@@ -46,15 +55,55 @@ func completions(ctx *gin.Context) {
 		middle.ErrResponse(ctx, -1, err)
 		return
 	}
+
+	middle.StartAudit(ctx, completion)
+	defer middle.EndAudit(ctx)
+	completeChoices(ctx, completion)
+}
+
+// 多个 choices
+func completeChoices(ctx *gin.Context, completion pkg.ChatCompletion) {
+	if completion.N > 1 {
+		middle.CompleteChoices(ctx, completion, completeFormat)
+		return
+	}
+	completeFormat(ctx, completion)
+}
+
+// 结构化输出，n > 1 时每个 choice 各自经过这里
+func completeFormat(ctx *gin.Context, completion pkg.ChatCompletion) {
+	// 补全以任何方式结束后，整形的定时器都不再输出
+	defer middle.CloseShaper(ctx)
+	if middle.NeedToFormat(completion) {
+		middle.CompleteFormat(ctx, completion, GlobalExtension.NativeFormat(ctx, completion.Model), completeContinue)
+		return
+	}
+	completeContinue(ctx, completion)
+}
+
+// 截断自动续写
+func completeContinue(ctx *gin.Context, completion pkg.ChatCompletion) {
+	if middle.NeedToContinue(completion) {
+		middle.CompleteContinue(ctx, completion, complete)
+		return
+	}
+	complete(ctx, completion)
+}
+
+func complete(ctx *gin.Context, completion pkg.ChatCompletion) {
 	ctx.Set(vars.GinCompletion, completion)
 	matchers := common.XmlFlags(ctx, &completion)
+	if matcher := middle.ReasoningMatcher(ctx); matcher != nil {
+		matchers = append([]pkg.Matcher{matcher}, matchers...)
+	}
 	ctx.Set(vars.GinMatchers, matchers)
+	middle.AuditMessages(ctx, completion.Messages)
 	if ctx.GetBool("debug") {
 		indent, err := json.MarshalIndent(completion, "", "  ")
 		if err != nil {
-			logrus.Warn(err)
+			common.Logger(ctx).Warn(err)
 		} else {
-			fmt.Printf("requset: \n%s", indent)
+			common.Logger(ctx).Infof("request: \n%s", indent)
 		}
 	}
 
@@ -78,7 +127,7 @@ func generations(ctx *gin.Context) {
 	}
 
 	ctx.Set(vars.GinGeneration, generation)
-	logrus.Infof("generate images text[ %s ]: %s", generation.Model, generation.Prompt)
+	common.Logger(ctx).Infof("generate images text[ %s ]: %s", generation.Model, generation.Prompt)
 	if !GlobalExtension.Match(ctx, generation.Model) {
 		middle.ErrResponse(ctx, -1, fmt.Sprintf("model '%s' is not not yet supported", generation.Model))
 		return
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/RomiChan/websocket"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// 客户端帧
+//
+//	type: completion 发起补全，completion 为请求体；cancel 取消 id 对应的补全
+type wsRequest struct {
+	Id         string          `json:"id"`
+	Type       string          `json:"type"`
+	Completion json.RawMessage `json:"completion,omitempty"`
+}
+
+// 服务端帧
+//
+//	type: delta 流式块、message 非流式响应、error 错误、done 补全结束、canceled 已取消
+type wsResponse struct {
+	Id   string      `json:"id"`
+	Type string      `json:"type"`
+	Code int         `json:"code,omitempty"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// 双向流式的 websocket 接口，一个连接上可以同时进行多个补全，
+// 每个补全复用 http 接口的处理流程，响应通过拦截器转为 websocket 帧。
+func completionsWs(ctx *gin.Context) {
+	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		common.Logger(ctx).Error(err)
+		return
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		running = make(map[string]context.CancelFunc)
+	)
+
+	write := func(response wsResponse) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return conn.WriteJSON(response)
+	}
+
+	defer func() {
+		mu.Lock()
+		for _, cancel := range running {
+			cancel()
+		}
+		mu.Unlock()
+		wg.Wait()
+		_ = conn.Close()
+	}()
+
+	for {
+		var request wsRequest
+		if err = conn.ReadJSON(&request); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				common.Logger(ctx).Error(err)
+			}
+			return
+		}
+
+		switch request.Type {
+		case "cancel":
+			mu.Lock()
+			cancel, ok := running[request.Id]
+			mu.Unlock()
+			if ok {
+				cancel()
+			}
+
+		case "completion":
+			var completion pkg.ChatCompletion
+			if err = json.Unmarshal(request.Completion, &completion); err != nil {
+				_ = write(wsResponse{Id: request.Id, Type: "error", Code: http.StatusBadRequest, Data: gin.H{"error": map[string]string{"message": err.Error()}}})
+				continue
+			}
+
+			mu.Lock()
+			if _, ok := running[request.Id]; ok {
+				mu.Unlock()
+				_ = write(wsResponse{Id: request.Id, Type: "error", Code: http.StatusBadRequest, Data: gin.H{"error": map[string]string{"message": "duplicate id: " + request.Id}}})
+				continue
+			}
+
+			id := request.Id
+			var child *gin.Context
+			child, cancel := middle.Fork(ctx, func(code int, data interface{}, sse bool) {
+				if _, ok := data.(string); ok {
+					return // "[DONE]"
+				}
+
+				middle.AuditResponse(child, code, data)
+				response := wsResponse{Id: id, Type: "message", Data: data}
+				if code != http.StatusOK {
+					response.Type = "error"
+					response.Code = code
+				} else if sse {
+					response.Type = "delta"
+				}
+
+				if e := write(response); e != nil {
+					common.Logger(ctx).Error(e)
+					child.Set(vars.GinClose, true)
+				}
+			})
+			child.Set(vars.GinRequestId, common.GetGinRequestId(ctx)+"-"+id)
+			running[id] = cancel
+			mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer cancel()
+				middle.StartAudit(child, completion)
+				completeWs(child, completion, func(err interface{}) {
+					data := gin.H{"error": map[string]string{"message": fmt.Sprintf("%v", err)}}
+					middle.AuditResponse(child, http.StatusInternalServerError, data)
+					_ = write(wsResponse{Id: id, Type: "error", Code: http.StatusInternalServerError, Data: data})
+				})
+				middle.EndAudit(child)
+
+				mu.Lock()
+				delete(running, id)
+				mu.Unlock()
+
+				if middle.IsCanceled(child.Request.Context()) {
+					_ = write(wsResponse{Id: id, Type: "canceled"})
+					return
+				}
+				_ = write(wsResponse{Id: id, Type: "done"})
+			}()
+
+		default:
+			_ = write(wsResponse{Id: request.Id, Type: "error", Code: http.StatusBadRequest, Data: gin.H{"error": map[string]string{"message": "unknown type: " + request.Type}}})
+		}
+	}
+}
+
+// 补全在独立的协程中执行，不受 gin 的 panicHandler 保护，panic 交由 recovered 以错误帧返回
+func completeWs(ctx *gin.Context, completion pkg.ChatCompletion, recovered func(err interface{})) {
+	defer func() {
+		if r := recover(); r != nil {
+			common.Logger(ctx).Errorf("response error: %v", r)
+			recovered(r)
+		}
+	}()
+	completeChoices(ctx, completion)
+}
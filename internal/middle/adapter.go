@@ -18,6 +18,10 @@ type Adapter interface {
 	Models() []Model
 	Completion(ctx *gin.Context)
 	Generation(ctx *gin.Context)
+	// 上游支持的采样参数，返回nil时不做校验
+	Sampling(ctx *gin.Context, model string) []string
+	// 上游原生支持 response_format 时返回true，结构化输出不再追加提示词
+	NativeFormat(ctx *gin.Context, model string) bool
 }
 
 type BaseAdapter struct {
@@ -37,6 +41,14 @@ func (BaseAdapter) Completion(*gin.Context) {
 func (BaseAdapter) Generation(*gin.Context) {
 }
 
+func (BaseAdapter) Sampling(*gin.Context, string) []string {
+	return nil
+}
+
+func (BaseAdapter) NativeFormat(*gin.Context, string) bool {
+	return false
+}
+
 func (adapter ExtensionAdapter) Match(ctx *gin.Context, model string) bool {
 	for _, extension := range adapter.Extensions {
 		if extension.Match(ctx, model) {
@@ -53,10 +65,32 @@ func (adapter ExtensionAdapter) Models() (models []Model) {
 	return
 }
 
+func (adapter ExtensionAdapter) Sampling(ctx *gin.Context, model string) []string {
+	for _, extension := range adapter.Extensions {
+		if extension.Match(ctx, model) {
+			return extension.Sampling(ctx, model)
+		}
+	}
+	return nil
+}
+
+func (adapter ExtensionAdapter) NativeFormat(ctx *gin.Context, model string) bool {
+	for _, extension := range adapter.Extensions {
+		if extension.Match(ctx, model) {
+			return extension.NativeFormat(ctx, model)
+		}
+	}
+	return false
+}
+
 func (adapter ExtensionAdapter) Completion(ctx *gin.Context) {
 	completion := common.GetGinCompletion(ctx)
 	for _, extension := range adapter.Extensions {
 		if extension.Match(ctx, completion.Model) {
+			if !SamplingValidator(ctx, extension.Sampling(ctx, completion.Model)) {
+				return
+			}
+			AuditAdapter(ctx, extension)
 			extension.Completion(ctx)
 			return
 		}
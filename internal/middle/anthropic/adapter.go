@@ -0,0 +1,70 @@
+package anthropic
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+var (
+	Adapter = API{}
+	Model   = "claude"
+)
+
+// 官方 Messages API。claude.ai 网页版的 sessionKey 同样以 sk-ant- 开头（sk-ant-sid），
+// 这里只接管 sk-ant-api 开头的 key，其余仍由 claude 适配器处理
+type API struct {
+	middle.BaseAdapter
+}
+
+func (API) Match(ctx *gin.Context, model string) bool {
+	token := ctx.GetString("token")
+	return strings.HasPrefix(token, "sk-ant-api") && strings.HasPrefix(model, Model)
+}
+
+func (API) Sampling(*gin.Context, string) []string {
+	return []string{"temperature", "top_p", "top_k", "max_tokens", "user"} // user 转为 metadata.user_id
+}
+
+func (API) Completion(ctx *gin.Context) {
+	var (
+		completion = common.GetGinCompletion(ctx)
+		matchers   = common.GetGinMatchers(ctx)
+	)
+
+	system, messages, tokens, err := mergeMessages(ctx, completion.Messages)
+	if err != nil {
+		middle.ErrResponse(ctx, http.StatusBadRequest, err)
+		return
+	}
+	ctx.Set("tokens", tokens)
+
+	model := completion.Model
+	if model == Model {
+		model = pkg.Config.GetString("anthropic.model")
+		if model == "" {
+			model = "claude-3-haiku-20240307"
+		}
+	}
+
+	response, err := fetch(ctx, model, system, messages, completion)
+	if err != nil {
+		middle.ErrResponse(ctx, -1, err)
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		middle.ErrResponse(ctx, response.StatusCode, errorMessage(response))
+		return
+	}
+
+	if completion.Stream {
+		waitSSEResponse(ctx, matchers, response, completion.Model)
+		return
+	}
+	waitResponse(ctx, matchers, response, completion.Model)
+}
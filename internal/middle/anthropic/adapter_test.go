@@ -0,0 +1,147 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMergeMessages(t *testing.T) {
+	system, messages, _, err := mergeMessages(nil, []pkg.Keyv[interface{}]{
+		{"role": "system", "content": "be brief"},
+		{"role": "user", "content": "weather?"},
+		{"role": "assistant", "content": "", "tool_calls": []interface{}{
+			map[string]interface{}{"id": "toolu_1", "type": "function", "function": map[string]interface{}{"name": "weather", "arguments": `{"city":"Paris"}`}},
+		}},
+		{"role": "tool", "tool_call_id": "toolu_1", "content": "sunny"},
+		{"role": "user", "content": "thanks"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if system != "be brief" || len(messages) != 3 {
+		t.Fatalf("unexpected messages: %s %v", system, messages)
+	}
+	// tool_result 与随后的 user 消息合并
+	if messages[2].Role != "user" || len(messages[2].Content) != 2 || messages[2].Content[0]["type"] != "tool_result" {
+		t.Fatalf("unexpected tool result: %v", messages[2])
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		if r.Header.Get("x-api-key") != "sk-ant-api03-test" || payload["system"] != "be brief" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+			return
+		}
+
+		if payload["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("event: message_start\n" + `data: {"type":"message_start","message":{"usage":{"input_tokens":12,"output_tokens":1}}}` + "\n\n" +
+				"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me check."}}` + "\n\n" +
+				"event: content_block_start\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"weather","input":{}}}` + "\n\n" +
+				"event: content_block_delta\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\": \"Paris\"}"}}` + "\n\n" +
+				"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}` + "\n\n" +
+				"event: message_stop\n" + `data: {"type":"message_stop"}` + "\n\n"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"type":"message","content":[{"type":"text","text":"Hello"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`))
+	}))
+	defer server.Close()
+
+	testutil.Config(t).Set("anthropic.baseUrl", server.URL)
+
+	complete := func(token string, stream bool) string {
+		ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+			Model:    "claude-3-haiku-20240307",
+			Stream:   stream,
+			Messages: []pkg.Keyv[interface{}]{{"role": "system", "content": "be brief"}, {"role": "user", "content": "hi"}},
+		})
+		ctx.Set("token", token)
+		if !Adapter.Match(ctx, "claude-3-haiku-20240307") {
+			return "unmatched"
+		}
+		Adapter.Completion(ctx)
+		return recorder.Body.String()
+	}
+
+	if body := complete("sk-ant-sid01-session", false); body != "unmatched" {
+		t.Fatalf("web session key should not match: %s", body)
+	}
+
+	if body := complete("sk-ant-api03-test", false); !strings.Contains(body, `"content":"Hello"`) || !strings.Contains(body, `"total_tokens":15`) {
+		t.Fatalf("unexpected response: %s", body)
+	}
+
+	body := complete("sk-ant-api03-test", true)
+	for _, expected := range []string{`"content":"Let me check."`, `"name":"weather"`, `"arguments":"{\"city\": \"Paris\"}"`, `"total_tokens":32`, "[DONE]"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("missing %s: %s", expected, body)
+		}
+	}
+
+	if body := complete("sk-ant-api03-wrong", false); !strings.Contains(body, "authentication_error") {
+		t.Fatalf("unexpected error: %s", body)
+	}
+}
+
+func TestStopReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		if payload["stream"] != true {
+			_, _ = w.Write([]byte(`{"type":"message","content":[{"type":"text","text":"Hel"}],"stop_reason":"max_tokens","usage":{"input_tokens":12,"output_tokens":1}}`))
+			return
+		}
+
+		events := "event: content_block_delta\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}` + "\n\n"
+		if payload["system"] == "cut" {
+			// 输出一部分后断开连接，读取时返回 unexpected EOF
+			conn, rw, _ := w.(http.Hijacker).Hijack()
+			_, _ = rw.WriteString("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nTransfer-Encoding: chunked\r\n\r\n")
+			_, _ = rw.WriteString(fmt.Sprintf("%x\r\n%s\r\n", len(events), events))
+			_ = rw.Flush()
+			_ = conn.Close()
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(events +
+			"event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":1}}` + "\n\n" +
+			"event: message_stop\n" + `data: {"type":"message_stop"}` + "\n\n"))
+	}))
+	defer server.Close()
+
+	testutil.Config(t).Set("anthropic.baseUrl", server.URL)
+
+	complete := func(system string, stream bool) string {
+		ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+			Model:    "claude-3-haiku-20240307",
+			Stream:   stream,
+			Messages: []pkg.Keyv[interface{}]{{"role": "system", "content": system}, {"role": "user", "content": "hi"}},
+		})
+		ctx.Set("token", "sk-ant-api03-test")
+		Adapter.Completion(ctx)
+		return recorder.Body.String()
+	}
+
+	for _, stream := range []bool{false, true} {
+		if body := complete("", stream); !strings.Contains(body, `"finish_reason":"length"`) {
+			t.Fatalf("expected length: %s", body)
+		}
+	}
+
+	// 流被截断时不输出结束块
+	if body := complete("cut", true); !strings.Contains(body, `"content":"Hel"`) || strings.Contains(body, "finish_reason\":\"stop") || strings.Contains(body, "[DONE]") {
+		t.Fatalf("unexpected response: %s", body)
+	}
+}
@@ -0,0 +1,127 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/bincooo/emit.io"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	baseUrl = "https://api.anthropic.com"
+	version = "2023-06-01"
+)
+
+// 请求 /v1/messages
+//
+//	anthropic.baseUrl:    默认 https://api.anthropic.com
+//	anthropic.version:    anthropic-version 请求头，默认 2023-06-01
+//	anthropic.max_tokens: 请求未指定 max_tokens 时使用，默认 4096
+func fetch(ctx *gin.Context, model, system string, messages []message, completion pkg.ChatCompletion) (*http.Response, error) {
+	maxTokens := completion.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = pkg.Config.GetInt("anthropic.max_tokens")
+	}
+	if maxTokens <= 0 {
+		maxTokens = 4096
+	}
+
+	payload := map[string]interface{}{
+		"model":      model,
+		"messages":   messages,
+		"max_tokens": maxTokens,
+		"stream":     completion.Stream,
+	}
+	if system != "" {
+		payload["system"] = system
+	}
+	if completion.Temperature != 0 {
+		payload["temperature"] = completion.Temperature
+	}
+	if completion.TopP != 0 {
+		payload["top_p"] = completion.TopP
+	}
+	if completion.TopK != 0 {
+		payload["top_k"] = completion.TopK
+	}
+	if len(completion.StopSequences) > 0 {
+		payload["stop_sequences"] = completion.StopSequences
+	}
+	if completion.User != "" {
+		payload["metadata"] = map[string]string{"user_id": completion.User}
+	}
+
+	if len(completion.Tools) > 0 && completion.ToolChoice != "none" {
+		payload["tools"] = convertTools(completion.Tools)
+		switch completion.ToolChoice {
+		case "", "auto":
+		case "required":
+			payload["tool_choice"] = map[string]string{"type": "any"}
+		default:
+			payload["tool_choice"] = map[string]string{"type": "tool", "name": completion.ToolChoice}
+		}
+	}
+
+	marshal, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	u := strings.TrimSuffix(pkg.Config.GetString("anthropic.baseUrl"), "/")
+	if u == "" {
+		u = baseUrl
+	}
+
+	v := pkg.Config.GetString("anthropic.version")
+	if v == "" {
+		v = version
+	}
+
+	return emit.ClientBuilder().
+		Proxies(ctx.GetString("proxies")).
+		Context(ctx.Request.Context()).
+		POST(u+"/v1/messages").
+		JHeader().
+		Header("x-api-key", ctx.GetString("token")).
+		Header("anthropic-version", v).
+		Bytes(marshal).
+		Do()
+}
+
+// OpenAI 格式的 tools 转为 {name, description, input_schema}
+func convertTools(tools []pkg.Keyv[interface{}]) (values []map[string]interface{}) {
+	for _, tool := range tools {
+		fn := tool.GetKeyv("function")
+		schema, ok := fn["parameters"]
+		if !ok || schema == nil {
+			schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+
+		value := map[string]interface{}{
+			"name":         fn.GetString("name"),
+			"input_schema": schema,
+		}
+		if description := fn.GetString("description"); description != "" {
+			value["description"] = description
+		}
+		values = append(values, value)
+	}
+	return
+}
+
+func errorMessage(response *http.Response) string {
+	data, err := io.ReadAll(response.Body)
+	if err != nil || len(data) == 0 {
+		return response.Status
+	}
+
+	var r messageResponse
+	if json.Unmarshal(data, &r) == nil && r.Error != nil {
+		return r.Error.Type + ": " + r.Error.Message
+	}
+	return fmt.Sprintf("%s: %s", response.Status, data)
+}
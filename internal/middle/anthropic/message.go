@@ -0,0 +1,364 @@
+package anthropic
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type message struct {
+	Role    string                   `json:"role"`
+	Content []map[string]interface{} `json:"content"`
+}
+
+type messageResponse struct {
+	Type         string                   `json:"type"`
+	Content      []map[string]interface{} `json:"content"`
+	StopReason   string                   `json:"stop_reason"`
+	Usage        *usage                   `json:"usage"`
+	Message      *messageResponse         `json:"message"`
+	Index        int                      `json:"index"`
+	ContentBlock map[string]interface{}   `json:"content_block"`
+	Delta        map[string]interface{}   `json:"delta"`
+	Error        *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// 转换为 Messages API 格式：system 单独提取，相邻同角色的消息合并，
+// tool 消息转为 user 中的 tool_result，assistant 的 tool_calls 转为 tool_use
+func mergeMessages(ctx *gin.Context, messages []pkg.Keyv[interface{}]) (system string, newMessages []message, tokens int, err error) {
+	var systems []string
+	for _, msg := range messages {
+		role := msg.GetString("role")
+		var blocks []map[string]interface{}
+
+		switch role {
+		case "system":
+			text := contentText(msg["content"])
+			tokens += common.CalcTokens(text)
+			if text != "" {
+				systems = append(systems, text)
+			}
+			continue
+
+		case "tool", "function":
+			text := contentText(msg["content"])
+			tokens += common.CalcTokens(text)
+			role = "user"
+			blocks = append(blocks, map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": msg.GetString("tool_call_id"),
+				"content":     text,
+			})
+
+		default:
+			if role != "assistant" {
+				role = "user"
+			}
+
+			if blocks, err = contentBlocks(ctx, msg["content"]); err != nil {
+				return
+			}
+			for _, block := range blocks {
+				if text, ok := block["text"].(string); ok {
+					tokens += common.CalcTokens(text)
+				}
+			}
+
+			calls, _ := msg["tool_calls"].([]interface{})
+			for _, it := range calls {
+				call, ok := it.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				fn := pkg.Keyv[interface{}](call).GetKeyv("function")
+				var input interface{} = map[string]interface{}{}
+				if args := fn.GetString("arguments"); args != "" {
+					if err = json.Unmarshal([]byte(args), &input); err != nil {
+						err = fmt.Errorf("tool_calls arguments: %v", err)
+						return
+					}
+				}
+				blocks = append(blocks, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    call["id"],
+					"name":  fn.GetString("name"),
+					"input": input,
+				})
+			}
+		}
+
+		if len(blocks) == 0 {
+			continue
+		}
+
+		if count := len(newMessages); count > 0 && newMessages[count-1].Role == role {
+			newMessages[count-1].Content = append(newMessages[count-1].Content, blocks...)
+			continue
+		}
+		newMessages = append(newMessages, message{role, blocks})
+	}
+
+	system = strings.Join(systems, "\n\n")
+	return
+}
+
+func contentText(content interface{}) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var texts []string
+		for _, it := range v {
+			if part, ok := it.(map[string]interface{}); ok && part["type"] == "text" {
+				text, _ := part["text"].(string)
+				texts = append(texts, text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	return ""
+}
+
+// 文本与图片块，空文本会被 API 拒绝，直接跳过
+func contentBlocks(ctx *gin.Context, content interface{}) (blocks []map[string]interface{}, err error) {
+	switch v := content.(type) {
+	case string:
+		if strings.TrimSpace(v) != "" {
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": v})
+		}
+	case []interface{}:
+		for _, it := range v {
+			part, ok := it.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			switch part["type"] {
+			case "text":
+				if text, _ := part["text"].(string); strings.TrimSpace(text) != "" {
+					blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+				}
+			case "image_url":
+				url, _ := part["image_url"].(string)
+				if image, o := part["image_url"].(map[string]interface{}); o {
+					url, _ = image["url"].(string)
+				}
+
+				mime, data, e := common.LoadImage(ctx.Request.Context(), ctx.GetString("proxies"), url)
+				if e != nil {
+					return nil, e
+				}
+				blocks = append(blocks, map[string]interface{}{
+					"type": "image",
+					"source": map[string]string{
+						"type":       "base64",
+						"media_type": mime,
+						"data":       data,
+					},
+				})
+			}
+		}
+	}
+	return
+}
+
+func usageTokens(u usage) map[string]int {
+	return map[string]int{
+		"prompt_tokens":     u.InputTokens,
+		"completion_tokens": u.OutputTokens,
+		"total_tokens":      u.InputTokens + u.OutputTokens,
+	}
+}
+
+// 记录上游的结束原因：max_tokens 转为 length，refusal 转为 content_filter，其余由响应方法按 stop、tool_calls 处理
+func stopReason(ctx *gin.Context, reason string) {
+	switch reason {
+	case "max_tokens":
+		ctx.Set(vars.GinFinishReason, "length")
+	case "refusal":
+		ctx.Set(vars.GinFinishReason, "content_filter")
+	}
+}
+
+func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, response *http.Response, model string) {
+	var r messageResponse
+	if err := json.NewDecoder(response.Body).Decode(&r); err != nil {
+		middle.ErrResponse(ctx, -1, err)
+		return
+	}
+
+	if r.Error != nil {
+		middle.ErrResponse(ctx, -1, r.Error.Type+": "+r.Error.Message)
+		return
+	}
+
+	stopReason(ctx, r.StopReason)
+	content := ""
+	var calls []pkg.Keyv[interface{}]
+	for _, block := range r.Content {
+		switch block["type"] {
+		case "text":
+			text, _ := block["text"].(string)
+			content += text
+		case "tool_use":
+			args, _ := json.Marshal(block["input"])
+			calls = append(calls, pkg.Keyv[interface{}]{
+				"id":   block["id"],
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      block["name"],
+					"arguments": string(args),
+				},
+			})
+		}
+	}
+
+	common.LogRaw(ctx, content)
+	content = pkg.ExecMatchers(matchers, content)
+	if r.Usage != nil {
+		ctx.Set(vars.GinCompletionUsage, usageTokens(*r.Usage))
+	} else {
+		ctx.Set(vars.GinCompletionUsage, common.CalcUsageTokens(content, ctx.GetInt("tokens")))
+	}
+
+	if len(calls) > 0 {
+		middle.ToolCallsResponse(ctx, model, content, calls)
+		return
+	}
+	middle.Response(ctx, model, content)
+}
+
+func waitSSEResponse(ctx *gin.Context, matchers []pkg.Matcher, response *http.Response, model string) {
+	var (
+		content  = ""
+		created  = time.Now().Unix()
+		u        usage
+		calls    = make(map[int]int) // content_block 索引 -> tool_calls 索引
+		scanner  = bufio.NewScanner(response.Body)
+		finished = false
+	)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4<<20)
+
+	for scanner.Scan() {
+		text := scanner.Text()
+		if !strings.HasPrefix(text, "data:") {
+			continue
+		}
+
+		var r messageResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(text[5:])), &r); err != nil {
+			common.Logger(ctx).Warnf("anthropic: %v: %s", err, text)
+			continue
+		}
+
+		switch r.Type {
+		case "error":
+			err := r.Type
+			if r.Error != nil {
+				err = r.Error.Type + ": " + r.Error.Message
+			}
+			common.Logger(ctx).Error(err)
+			if middle.NotSSEHeader(ctx) {
+				middle.ErrResponse(ctx, -1, err)
+			}
+			return
+
+		case "message_start":
+			if r.Message != nil && r.Message.Usage != nil {
+				u.InputTokens = r.Message.Usage.InputTokens
+			}
+
+		case "content_block_start":
+			if r.ContentBlock["type"] != "tool_use" {
+				continue
+			}
+			index := len(calls)
+			calls[r.Index] = index
+			middle.SSEToolCallsResponse(ctx, model, []pkg.Keyv[interface{}]{{
+				"index": index,
+				"id":    r.ContentBlock["id"],
+				"type":  "function",
+				"function": map[string]interface{}{
+					"name":      r.ContentBlock["name"],
+					"arguments": "",
+				},
+			}}, created)
+
+		case "content_block_delta":
+			switch r.Delta["type"] {
+			case "text_delta":
+				raw, _ := r.Delta["text"].(string)
+				if raw == "" {
+					continue
+				}
+				common.LogRaw(ctx, raw)
+				raw = pkg.ExecMatchers(matchers, raw)
+				middle.SSEResponse(ctx, model, raw, created)
+				content += raw
+			case "input_json_delta":
+				args, _ := r.Delta["partial_json"].(string)
+				middle.SSEToolCallsResponse(ctx, model, []pkg.Keyv[interface{}]{{
+					"index":    calls[r.Index],
+					"function": map[string]interface{}{"arguments": args},
+				}}, created)
+			}
+
+		case "message_delta":
+			if r.Usage != nil {
+				u.OutputTokens = r.Usage.OutputTokens
+			}
+			if reason, ok := r.Delta["stop_reason"].(string); ok {
+				stopReason(ctx, reason)
+			}
+
+		case "message_stop":
+			finished = true
+		}
+
+		if finished {
+			break
+		}
+	}
+
+	if !finished && middle.IsClosed(ctx) {
+		middle.Abandon[string](ctx, nil)
+		return
+	}
+
+	if err := scanner.Err(); err != nil && !finished {
+		common.Logger(ctx).Errorf("anthropic: %v", err)
+		if middle.NotSSEHeader(ctx) {
+			middle.ErrResponse(ctx, -1, err)
+		}
+		return
+	}
+
+	if u.OutputTokens > 0 {
+		ctx.Set(vars.GinCompletionUsage, usageTokens(u))
+	} else {
+		ctx.Set(vars.GinCompletionUsage, common.CalcUsageTokens(content, ctx.GetInt("tokens")))
+	}
+
+	if len(calls) > 0 {
+		middle.SSEToolCallsResponse(ctx, model, nil, created)
+		return
+	}
+	middle.SSEResponse(ctx, model, "[DONE]", created)
+}
@@ -0,0 +1,365 @@
+package middle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 审计记录，每个补全一条
+type AuditRecord struct {
+	Time      string                  `json:"time"`
+	RequestId string                  `json:"request_id,omitempty"`
+	ClientKey string                  `json:"client_key,omitempty"`
+	User      string                  `json:"user,omitempty"`
+	Model     string                  `json:"model"`
+	Adapter   string                  `json:"adapter,omitempty"`
+	Stream    bool                    `json:"stream"`
+	Messages  []pkg.Keyv[interface{}] `json:"messages,omitempty"`
+	Content   []string                `json:"content,omitempty"`
+	ToolCalls []pkg.Keyv[interface{}] `json:"tool_calls,omitempty"`
+	Usage     map[string]int          `json:"usage,omitempty"`
+	Latency   int64                   `json:"latency_ms"`
+	Status    int                     `json:"status"`
+	Error     string                  `json:"error,omitempty"`
+	// 错误发生在流式输出开始之后，客户端收到的状态码仍为 200
+	Interrupted bool `json:"interrupted,omitempty"`
+
+	mu       sync.Mutex
+	start    time.Time
+	content  bool
+	streamed bool
+	// 流式 tool_calls 按 choice 与 index 合并后在 ToolCalls 中的位置
+	calls map[string]int
+}
+
+// 按大小、时间滚动的 jsonl 文件
+type auditWriter struct {
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+var auditFile = &auditWriter{}
+
+// 开始记录审计：audit.enabled 开启并命中 audit.sample 采样率时生效
+//
+//	audit.content: 是否记录 messages 与回复内容，关闭时只记录元数据
+func StartAudit(ctx *gin.Context, completion pkg.ChatCompletion) {
+	if !pkg.Config.GetBool("audit.enabled") {
+		return
+	}
+
+	sample := 1.0
+	if pkg.Config.IsSet("audit.sample") {
+		sample = pkg.Config.GetFloat64("audit.sample")
+	}
+	if rand.Float64() >= sample {
+		return
+	}
+
+	record := &AuditRecord{
+		RequestId: common.GetGinRequestId(ctx),
+		User:      completion.User,
+		Model:     completion.Model,
+		Stream:    completion.Stream,
+		start:     time.Now(),
+		content:   pkg.Config.GetBool("audit.content"),
+	}
+
+	if token := ctx.GetString("token"); token != "" {
+		hash := sha256.Sum256([]byte(token))
+		record.ClientKey = hex.EncodeToString(hash[:8])
+	}
+	ctx.Set(vars.GinAudit, record)
+}
+
+// 结束并写出审计记录
+func EndAudit(ctx *gin.Context) {
+	record, ok := common.GetGinValue[*AuditRecord](ctx, vars.GinAudit)
+	if !ok {
+		return
+	}
+
+	record.mu.Lock()
+	defer record.mu.Unlock()
+
+	record.Time = record.start.Format(time.RFC3339Nano)
+	record.Latency = time.Since(record.start).Milliseconds()
+	if record.Status == 0 {
+		record.Status = http.StatusOK
+	}
+	if record.Error == "" && IsClosed(ctx) {
+		record.Error = "canceled"
+	}
+
+	marshal, err := json.Marshal(record)
+	if err != nil {
+		common.Logger(ctx).Error(err)
+		return
+	}
+
+	if err = auditFile.write(append(marshal, '\n')); err != nil {
+		common.Logger(ctx).Errorf("audit write failed: %v", err)
+	}
+}
+
+// 记录处理标记后的 messages
+func AuditMessages(ctx *gin.Context, messages []pkg.Keyv[interface{}]) {
+	record, ok := common.GetGinValue[*AuditRecord](ctx, vars.GinAudit)
+	if !ok || !record.content {
+		return
+	}
+
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	record.Messages = messages
+}
+
+// 记录选中的适配器
+func AuditAdapter(ctx *gin.Context, adapter Adapter) {
+	record, ok := common.GetGinValue[*AuditRecord](ctx, vars.GinAudit)
+	if !ok {
+		return
+	}
+
+	t := reflect.TypeOf(adapter)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	record.Adapter = filepath.Base(t.PkgPath())
+}
+
+// 记录写给客户端的响应
+func AuditResponse(ctx *gin.Context, code int, data interface{}) {
+	record, ok := common.GetGinValue[*AuditRecord](ctx, vars.GinAudit)
+	if !ok {
+		return
+	}
+
+	record.mu.Lock()
+	defer record.mu.Unlock()
+
+	if code != http.StatusOK {
+		record.Error = errorClass(code)
+		if record.streamed {
+			record.Interrupted = true
+			return
+		}
+		record.Status = code
+		return
+	}
+
+	response, ok := data.(pkg.ChatResponse)
+	if !ok {
+		return
+	}
+
+	if response.Object == "chat.completion.chunk" {
+		record.streamed = true
+	}
+
+	if response.Usage != nil {
+		record.Usage = response.Usage
+	}
+
+	for _, choice := range response.Choices {
+		message := choice.Message
+		if message == nil {
+			message = choice.Delta
+		}
+		if message == nil {
+			continue
+		}
+
+		if choice.Delta != nil {
+			for _, call := range message.ToolCalls {
+				record.mergeToolCall(choice.Index, call)
+			}
+		} else if len(message.ToolCalls) > 0 {
+			record.ToolCalls = append(record.ToolCalls, message.ToolCalls...)
+		}
+
+		if !record.content || message.Content == "" {
+			continue
+		}
+
+		for len(record.Content) <= choice.Index {
+			record.Content = append(record.Content, "")
+		}
+		record.Content[choice.Index] += message.Content
+	}
+}
+
+// 合并流式的 tool_calls 增量：相同 index 的 arguments 依次拼接，其它字段以首次出现的为准
+func (record *AuditRecord) mergeToolCall(choice int, delta pkg.Keyv[interface{}]) {
+	key := fmt.Sprintf("%d:%v", choice, delta["index"])
+	pos, ok := record.calls[key]
+	if !ok {
+		if record.calls == nil {
+			record.calls = make(map[string]int)
+		}
+		record.calls[key] = len(record.ToolCalls)
+		record.ToolCalls = append(record.ToolCalls, pkg.Keyv[interface{}]{})
+		pos = len(record.ToolCalls) - 1
+	}
+
+	call := record.ToolCalls[pos]
+	for k, v := range delta {
+		if _, exists := call[k]; !exists && k != "function" {
+			call[k] = v
+		}
+	}
+
+	function, _ := call["function"].(map[string]interface{})
+	if function == nil {
+		function = make(map[string]interface{})
+		call["function"] = function
+	}
+
+	var values map[string]interface{}
+	switch value := delta["function"].(type) {
+	case map[string]interface{}:
+		values = value
+	case map[string]string:
+		values = make(map[string]interface{})
+		for k, v := range value {
+			values[k] = v
+		}
+	}
+
+	for k, v := range values {
+		if k == "arguments" {
+			args, _ := function[k].(string)
+			more, _ := v.(string)
+			function[k] = args + more
+			continue
+		}
+		if _, exists := function[k]; !exists {
+			function[k] = v
+		}
+	}
+}
+
+func errorClass(code int) string {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return "unauthorized"
+	case code == http.StatusTooManyRequests:
+		return "rate_limited"
+	case code >= 400 && code < 500:
+		return "bad_request"
+	default:
+		return "upstream_error"
+	}
+}
+
+// audit.path: 目录，默认 audit
+// audit.max_size: 单个文件的最大大小（MB），默认 100
+// audit.max_age: 单个文件的最长写入时间（小时），默认 24
+// audit.retention: 文件保留天数，0 不清理
+func (w *auditWriter) write(data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	maxSize := pkg.Config.GetInt64("audit.max_size")
+	if maxSize <= 0 {
+		maxSize = 100
+	}
+
+	maxAge := pkg.Config.GetInt64("audit.max_age")
+	if maxAge <= 0 {
+		maxAge = 24
+	}
+
+	if w.file != nil && (w.size+int64(len(data)) > maxSize<<20 || time.Since(w.created) > time.Duration(maxAge)*time.Hour) {
+		_ = w.file.Close()
+		w.file = nil
+	}
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	return err
+}
+
+func (w *auditWriter) open() error {
+	dir := pkg.Config.GetString("audit.path")
+	if dir == "" {
+		dir = "audit"
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	name := filepath.Join(dir, fmt.Sprintf("audit-%s.jsonl", now.Format("20060102-150405.000")))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.size = 0
+	w.created = now
+	go cleanAudit(dir)
+	return nil
+}
+
+// 清理超过保留天数的文件
+func cleanAudit(dir string) {
+	retention := pkg.Config.GetInt("audit.retention")
+	if retention <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "audit-") && strings.HasSuffix(entry.Name(), ".jsonl") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	deadline := time.Now().AddDate(0, 0, -retention)
+	for _, name := range names {
+		info, e := os.Stat(filepath.Join(dir, name))
+		if e != nil || info.ModTime().After(deadline) {
+			continue
+		}
+		if e = os.Remove(filepath.Join(dir, name)); e != nil {
+			logrus.Error(e)
+		}
+	}
+}
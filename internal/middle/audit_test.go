@@ -0,0 +1,72 @@
+package middle
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func auditRecord(t *testing.T, completion pkg.ChatCompletion) (*gin.Context, *AuditRecord) {
+	testutil.Config(t).Set("audit.enabled", true)
+	ctx, _ := testutil.Completion(completion)
+	StartAudit(ctx, completion)
+	record, ok := common.GetGinValue[*AuditRecord](ctx, vars.GinAudit)
+	if !ok {
+		t.Fatal("audit record not started")
+	}
+	return ctx, record
+}
+
+func TestAuditToolCallDeltas(t *testing.T) {
+	ctx, record := auditRecord(t, pkg.ChatCompletion{Stream: true})
+	created := time.Now().Unix()
+
+	SSEToolCallsResponse(ctx, "mock", []pkg.Keyv[interface{}]{
+		{"index": 0, "id": "call_1", "type": "function", "function": map[string]interface{}{"name": "search", "arguments": ""}},
+		{"index": 1, "id": "call_2", "type": "function", "function": map[string]interface{}{"name": "fetch", "arguments": ""}},
+	}, created)
+	SSEToolCallsResponse(ctx, "mock", []pkg.Keyv[interface{}]{{"index": 0, "function": map[string]interface{}{"arguments": `{"q":`}}}, created)
+	SSEToolCallsResponse(ctx, "mock", []pkg.Keyv[interface{}]{{"index": 1, "function": map[string]interface{}{"arguments": `{}`}}}, created)
+	SSEToolCallsResponse(ctx, "mock", []pkg.Keyv[interface{}]{{"index": 0, "function": map[string]interface{}{"arguments": `"go"}`}}}, created)
+	SSEToolCallsResponse(ctx, "mock", nil, created)
+
+	if len(record.ToolCalls) != 2 {
+		t.Fatalf("unexpected tool calls: %v", record.ToolCalls)
+	}
+
+	for pos, expected := range [][3]string{{"call_1", "search", `{"q":"go"}`}, {"call_2", "fetch", `{}`}} {
+		call := record.ToolCalls[pos]
+		function := call["function"].(map[string]interface{})
+		if call["id"] != expected[0] || function["name"] != expected[1] || function["arguments"] != expected[2] {
+			t.Fatalf("unexpected tool call[%d]: %v", pos, call)
+		}
+	}
+
+	// 旧的单个工具调用以 map[string]string 输出
+	ctx, record = auditRecord(t, pkg.ChatCompletion{Stream: true})
+	SSEToolCallResponse(ctx, "mock", "search", `{"q":"go"}`, created)
+	if len(record.ToolCalls) != 1 || record.ToolCalls[0]["function"].(map[string]interface{})["arguments"] != `{"q":"go"}` {
+		t.Fatalf("unexpected tool calls: %v", record.ToolCalls)
+	}
+}
+
+func TestAuditErrors(t *testing.T) {
+	ctx, record := auditRecord(t, pkg.ChatCompletion{})
+	ErrResponse(ctx, http.StatusTooManyRequests, "busy")
+	if record.Status != http.StatusTooManyRequests || record.Error != "rate_limited" || record.Interrupted {
+		t.Fatalf("unexpected record: status=%d error=%s", record.Status, record.Error)
+	}
+
+	// 流式输出开始后的错误：状态码保持 200，标记为中断
+	ctx, record = auditRecord(t, pkg.ChatCompletion{Stream: true})
+	SSEResponse(ctx, "mock", "hi", time.Now().Unix())
+	ErrResponse(ctx, http.StatusBadGateway, "upstream closed")
+	if record.Status != 0 || record.Error != "upstream_error" || !record.Interrupted {
+		t.Fatalf("unexpected record: status=%d error=%s", record.Status, record.Error)
+	}
+}
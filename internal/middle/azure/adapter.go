@@ -0,0 +1,200 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle/openai"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/bincooo/emit.io"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var (
+	Adapter = API{}
+	Model   = "azure"
+)
+
+// 对外的模型名到 Azure 部署的映射
+type deployment struct {
+	Model      string `mapstructure:"model"`
+	Resource   string `mapstructure:"resource"`
+	Endpoint   string `mapstructure:"endpoint"`
+	Deployment string `mapstructure:"deployment"`
+	ApiVersion string `mapstructure:"api_version"`
+	Key        string `mapstructure:"key"`
+	AAD        bool   `mapstructure:"aad"`
+}
+
+type API struct {
+	middle.BaseAdapter
+}
+
+func (API) Match(ctx *gin.Context, model string) bool {
+	_, ok := matchDeployment(ctx, model)
+	return ok
+}
+
+// response_format 随 openai 的请求体转发
+func (API) NativeFormat(*gin.Context, string) bool {
+	return true
+}
+
+func (API) Models() (models []middle.Model) {
+	for _, d := range deployments.Get(nil) {
+		models = append(models, middle.Model{
+			Id:      d.Model,
+			Object:  "model",
+			Created: 1686935002,
+			By:      Model + "-adapter",
+		})
+	}
+	return
+}
+
+func (API) Completion(ctx *gin.Context) {
+	var (
+		completion = common.GetGinCompletion(ctx)
+		matchers   = common.GetGinMatchers(ctx)
+	)
+
+	d, _ := matchDeployment(ctx, completion.Model)
+	response, err := fetch(ctx, d, completion)
+	if err != nil {
+		middle.ErrResponse(ctx, -1, err)
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		code, message := errorMessage(response)
+		if code != "" {
+			middle.ErrCodeResponse(ctx, response.StatusCode, code, message)
+			return
+		}
+		middle.ErrResponse(ctx, response.StatusCode, message)
+		return
+	}
+
+	tokens := 0
+	for _, message := range completion.Messages {
+		tokens += common.CalcTokens(message.GetString("content"))
+	}
+	ctx.Set("tokens", tokens)
+
+	if completion.Stream {
+		openai.WaitSSEResponse(ctx, matchers, response, completion.Model)
+		return
+	}
+	openai.WaitResponse(ctx, matchers, response, completion.Model)
+}
+
+// 配置中的部署列表，解析结果随配置缓存
+var deployments = common.NewConfigValue(func(config *viper.Viper) (values []deployment, err error) {
+	if err = config.UnmarshalKey("azure", &values); err != nil {
+		return nil, fmt.Errorf("azure: %v", err)
+	}
+	return
+})
+
+func matchDeployment(ctx *gin.Context, model string) (deployment, bool) {
+	for _, d := range deployments.Get(ctx) {
+		if d.Model == model {
+			return d, true
+		}
+	}
+	return deployment{}, false
+}
+
+// 请求 {endpoint}/openai/deployments/{deployment}/chat/completions?api-version=xxx
+//
+// 鉴权：aad 开启时 key 作为 AAD 的 bearer token，否则作为 api-key；
+// key 为空时使用请求的 Authorization，eyJ 开头（JWT）的视为 AAD token
+func fetch(ctx *gin.Context, d deployment, completion pkg.ChatCompletion) (*http.Response, error) {
+	marshal, err := json.Marshal(openai.NewPayload(d.Deployment, completion))
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimSuffix(d.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://" + d.Resource + ".openai.azure.com"
+	}
+
+	version := d.ApiVersion
+	if version == "" {
+		version = "2024-02-01"
+	}
+
+	key, aad := d.Key, d.AAD
+	if key == "" {
+		key = ctx.GetString("token")
+		aad = strings.HasPrefix(key, "eyJ")
+	}
+
+	builder := emit.ClientBuilder().
+		Proxies(ctx.GetString("proxies")).
+		Context(ctx.Request.Context()).
+		POST(fmt.Sprintf("%s/openai/deployments/%s/chat/completions", endpoint, d.Deployment)).
+		Query("api-version", version).
+		JHeader().
+		Bytes(marshal)
+	if aad {
+		builder.Header("Authorization", "Bearer "+key)
+	} else {
+		builder.Header("api-key", key)
+	}
+	return builder.Do()
+}
+
+// Azure 的错误，内容过滤时 code 为 content_filter，innererror 中带有各分类的结果
+type azureError struct {
+	Error *struct {
+		Code       string `json:"code"`
+		Message    string `json:"message"`
+		InnerError *struct {
+			Code                string `json:"code"`
+			ContentFilterResult map[string]struct {
+				Filtered bool   `json:"filtered"`
+				Severity string `json:"severity"`
+				Detected bool   `json:"detected"`
+			} `json:"content_filter_result"`
+		} `json:"innererror"`
+	} `json:"error"`
+}
+
+// 返回上游的错误码和信息，内容过滤时信息为 content filtered: hate(high), violence(medium) 的形式
+func errorMessage(response *http.Response) (code, message string) {
+	data, err := io.ReadAll(response.Body)
+	if err != nil || len(data) == 0 {
+		return "", response.Status
+	}
+
+	var r azureError
+	if json.Unmarshal(data, &r) != nil || r.Error == nil {
+		return "", fmt.Sprintf("%s: %s", response.Status, data)
+	}
+
+	if r.Error.Code != "content_filter" || r.Error.InnerError == nil {
+		return r.Error.Code, r.Error.Message
+	}
+
+	var categories []string
+	for category, result := range r.Error.InnerError.ContentFilterResult {
+		if !result.Filtered {
+			continue
+		}
+		if result.Severity != "" {
+			category += "(" + result.Severity + ")"
+		}
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return r.Error.Code, "content filtered: " + strings.Join(categories, ", ")
+}
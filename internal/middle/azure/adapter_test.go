@@ -0,0 +1,96 @@
+package azure
+
+import (
+	"encoding/json"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompletion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments/gpt-4o-prod/chat/completions" || r.URL.Query().Get("api-version") != "2024-02-01" || r.Header.Get("api-key") != "az-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":"401","message":"Access denied"}}`))
+			return
+		}
+
+		var payload map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		messages, _ := payload["messages"].([]interface{})
+		content := messages[0].(map[string]interface{})["content"]
+		if content == "busy" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":{"code":"429","message":"Rate limit is exceeded"}}`))
+			return
+		}
+		if content == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"code":"content_filter","message":"The response was filtered","innererror":{"code":"ResponsibleAIPolicyViolation","content_filter_result":{"hate":{"filtered":false,"severity":"safe"},"violence":{"filtered":true,"severity":"medium"}}}}}`))
+			return
+		}
+
+		if payload["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			if content == "cut" {
+				// 部署的流在中途断开
+				_, _ = w.Write([]byte(`data: {"choices":[{"index":0,"delta":{"role":"assistant","content":"Once"}}]}` + "\n\n" + `data: {"choices":[{"index":0,"del`))
+				return
+			}
+			_, _ = w.Write([]byte(`data: {"choices":[],"prompt_filter_results":[{"prompt_index":0}]}` + "\n\n" +
+				`data: {"choices":[{"index":0,"delta":{"role":"assistant","content":"Once"}}]}` + "\n\n" +
+				`data: {"choices":[{"index":0,"delta":{},"finish_reason":"content_filter"}]}` + "\n\n" +
+				"data: [DONE]\n\n"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`))
+	}))
+	defer server.Close()
+
+	testutil.Config(t).Set("azure", []interface{}{
+		map[string]interface{}{"model": "gpt-4o", "endpoint": server.URL, "deployment": "gpt-4o-prod", "key": "az-key"},
+	})
+
+	if !Adapter.Match(nil, "gpt-4o") || Adapter.Match(nil, "gpt-4") {
+		t.Fatal("unexpected match")
+	}
+
+	complete := func(content string, stream bool) string {
+		ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+			Model:    "gpt-4o",
+			Stream:   stream,
+			Messages: []pkg.Keyv[interface{}]{{"role": "user", "content": content}},
+		})
+		Adapter.Completion(ctx)
+		return recorder.Body.String()
+	}
+
+	if body := complete("hi", false); !strings.Contains(body, `"content":"Hello"`) || !strings.Contains(body, `"finish_reason":"stop"`) {
+		t.Fatalf("unexpected response: %s", body)
+	}
+
+	body := complete("hi", true)
+	for _, expected := range []string{`"content":"Once"`, `"finish_reason":"content_filter"`, "[DONE]"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("missing %s: %s", expected, body)
+		}
+	}
+
+	body = complete("bad", false)
+	if !strings.Contains(body, `"code":"content_filter"`) || !strings.Contains(body, `"type":"invalid_request_error"`) || !strings.Contains(body, "violence(medium)") || strings.Contains(body, "hate") {
+		t.Fatalf("unexpected error: %s", body)
+	}
+
+	// 错误类型由状态码决定
+	if body = complete("busy", false); !strings.Contains(body, `"code":"429"`) || !strings.Contains(body, `"type":"rate_limit_error"`) {
+		t.Fatalf("unexpected error: %s", body)
+	}
+
+	body = complete("cut", true)
+	if !strings.Contains(body, `"content":"Once"`) || !strings.Contains(body, `"finish_reason":"length"`) || strings.Contains(body, `"finish_reason":"stop"`) {
+		t.Fatalf("unexpected response: %s", body)
+	}
+}
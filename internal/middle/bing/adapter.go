@@ -7,7 +7,6 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/edge-api"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"regexp"
 	"strings"
 )
@@ -15,25 +14,46 @@ import (
 var (
 	Adapter = API{}
 	Model   = "bing"
+
+	// 对话风格对应的模型，bing 为 Sydney 模式，按 temperature 选择风格
+	variants = []variant{
+		{Model, edge.ModelSydney, false},
+		{Model + "-creative", edge.ModelCreative, false},
+		{Model + "-balanced", edge.ModelBalanced, false},
+		{Model + "-precise", edge.ModelPrecise, false},
+		{Model + "-notebook", edge.ModelSydney, true},
+	}
 )
 
+type variant struct {
+	name     string
+	model    string
+	notebook bool
+}
+
 type API struct {
 	middle.BaseAdapter
 }
 
 func (API) Match(_ *gin.Context, model string) bool {
-	return Model == model
+	_, ok := matchVariant(model)
+	return ok
 }
 
-func (API) Models() []middle.Model {
-	return []middle.Model{
-		{
-			Id:      Model,
+func (API) Sampling(*gin.Context, string) []string {
+	return []string{"temperature"}
+}
+
+func (API) Models() (models []middle.Model) {
+	for _, v := range variants {
+		models = append(models, middle.Model{
+			Id:      v.name,
 			Object:  "model",
 			Created: 1686935002,
 			By:      Model + "-adapter",
-		},
+		})
 	}
+	return
 }
 
 func (API) Completion(ctx *gin.Context) {
@@ -59,25 +79,23 @@ func (API) Completion(ctx *gin.Context) {
 		}
 	}
 
+	v, _ := matchVariant(completion.Model)
 	chat := edge.New(options.
 		Proxies(proxies).
 		TopicToE(true).
-		Model(edge.ModelSydney).
+		Model(v.model).
 		Temperature(completion.Temperature))
-	if notebook {
+	if notebook || v.notebook {
 		chat.Notebook(true)
 	}
 
-	maxCount := 8
-	if chat.IsLogin() {
-		maxCount = 28
-	}
-
-	pMessages, currMessage, tokens := mergeMessages(pad, maxCount, completion.Messages)
+	maxCount, page := rounds(v.name, chat.IsLogin())
+	pMessages, currMessage, tokens := mergeMessages(pad, page, maxCount, completion.Messages)
 
 	// 清理多余的标签
 	var cancel chan error
-	cancel, matchers = joinMatchers(ctx, matchers)
+	c := newCitations(ctx)
+	cancel, matchers = joinMatchers(ctx, c, matchers)
 	ctx.Set("tokens", tokens)
 	chatResponse, err := chat.Reply(ctx.Request.Context(), currMessage, pMessages)
 	if err != nil {
@@ -87,15 +105,46 @@ func (API) Completion(ctx *gin.Context) {
 
 	slices := strings.Split(chat.GetSession().ConversationId, "|")
 	if len(slices) > 1 {
-		logrus.Infof("bing status: [%s]", slices[1])
+		common.Logger(ctx).Infof("bing status: [%s]", slices[1])
+	}
+	waitResponse(ctx, matchers, c, cancel, chatResponse, completion.Stream)
+}
+
+func matchVariant(model string) (variant, bool) {
+	for _, v := range variants {
+		if v.name == model {
+			return v, true
+		}
 	}
-	waitResponse(ctx, matchers, cancel, chatResponse, completion.Stream)
+	return variant{}, false
 }
 
-func joinMatchers(ctx *gin.Context, matchers []pkg.Matcher) (chan error, []pkg.Matcher) {
+// 对话轮次上限，默认未登录 8 轮、已登录 28 轮，可在 config.yaml 的 bing.variants 中按模型配置
+//
+//	page: 超出轮次的对话是否转为网页上下文，false 时丢弃
+func rounds(model string, login bool) (max int, page bool) {
+	max, page = 8, true
+	if login {
+		max = 28
+	}
+
+	key := "bing.variants." + model
+	if login && pkg.Config.IsSet(key+".login_rounds") {
+		max = pkg.Config.GetInt(key + ".login_rounds")
+	}
+	if !login && pkg.Config.IsSet(key+".rounds") {
+		max = pkg.Config.GetInt(key + ".rounds")
+	}
+	if pkg.Config.IsSet(key + ".page") {
+		page = pkg.Config.GetBool(key + ".page")
+	}
+	return
+}
+
+func joinMatchers(ctx *gin.Context, c *citations, matchers []pkg.Matcher) (chan error, []pkg.Matcher) {
 	// 清理 [1]、[2] 标签
-	// 清理 [^1^]、[^2^] 标签
-	// 清理 [^1^ 标签
+	// 处理 [^1^]、[^2^] 引用标签
+	// 处理 [^1^ 标签
 	matchers = append(matchers, &pkg.SymbolMatcher{
 		Find: "[",
 		H: func(index int, content string) (state int, result string) {
@@ -112,11 +161,11 @@ func joinMatchers(ctx *gin.Context, matchers []pkg.Matcher) (chan error, []pkg.M
 			regexCompile = regexp.MustCompile(`\[\^\d+\^]:`)
 			content = regexCompile.ReplaceAllString(content, "")
 			regexCompile = regexp.MustCompile(`\[\^\d+\^]`)
-			content = regexCompile.ReplaceAllString(content, "")
+			content = c.replaceAll(regexCompile, content)
 			regexCompile = regexp.MustCompile(`\[\^\d+\^\^`)
-			content = regexCompile.ReplaceAllString(content, "")
+			content = c.replaceAll(regexCompile, content)
 			regexCompile = regexp.MustCompile(`\[\^\d+\^`)
-			content = regexCompile.ReplaceAllString(content, "")
+			content = c.replaceAll(regexCompile, content)
 			if strings.HasSuffix(content, "[") || strings.HasSuffix(content, "[^") {
 				return vars.MatMatching, content
 			}
@@ -138,11 +187,11 @@ func joinMatchers(ctx *gin.Context, matchers []pkg.Matcher) (chan error, []pkg.M
 			regexCompile := regexp.MustCompile(`\(\^\d+\^\):`)
 			content = regexCompile.ReplaceAllString(content, "")
 			regexCompile = regexp.MustCompile(`\(\^\d+\^\)`)
-			content = regexCompile.ReplaceAllString(content, "")
+			content = c.replaceAll(regexCompile, content)
 			regexCompile = regexp.MustCompile(`\(\^\d+\^\^`)
-			content = regexCompile.ReplaceAllString(content, "")
+			content = c.replaceAll(regexCompile, content)
 			regexCompile = regexp.MustCompile(`\(\^\d+\^`)
-			content = regexCompile.ReplaceAllString(content, "")
+			content = c.replaceAll(regexCompile, content)
 			if strings.HasSuffix(content, "(") || strings.HasSuffix(content, "(^") {
 				return vars.MatMatching, content
 			}
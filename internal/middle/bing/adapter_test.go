@@ -0,0 +1,39 @@
+package bing
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"testing"
+)
+
+func TestVariants(t *testing.T) {
+	testutil.Config(t).Set("bing.variants.bing-precise", map[string]interface{}{"rounds": 1, "page": false})
+
+	if !Adapter.Match(nil, "bing-precise") || !Adapter.Match(nil, "bing-notebook") || Adapter.Match(nil, "bing-unknown") {
+		t.Fatal("unexpected match")
+	}
+
+	if max, page := rounds("bing", true); max != 28 || !page {
+		t.Fatalf("unexpected default rounds: %d %v", max, page)
+	}
+
+	max, page := rounds("bing-precise", false)
+	if max != 1 || page {
+		t.Fatalf("unexpected variant rounds: %d %v", max, page)
+	}
+
+	messages := []pkg.Keyv[interface{}]{
+		{"role": "user", "content": "1"}, {"role": "assistant", "content": "a"},
+		{"role": "user", "content": "2"}, {"role": "assistant", "content": "b"},
+		{"role": "user", "content": "3"},
+	}
+
+	pMessages, text, _ := mergeMessages(false, page, max, messages)
+	if text != "<|user|>\n3\n<|end|>" || len(pMessages) != 2 {
+		t.Fatalf("unexpected messages: %q %v", text, pMessages)
+	}
+
+	if pMessages, _, _ = mergeMessages(false, true, max, messages); len(pMessages) != 3 {
+		t.Fatalf("unexpected page messages: %v", pMessages)
+	}
+}
@@ -0,0 +1,213 @@
+package bing
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 引用来源 [^1^]、(^1^) 的处理方式
+const (
+	citationStrip       = "strip"       // 删除（默认）
+	citationAnnotations = "annotations" // 删除并以 url_citation 返回在 annotations 中
+	citationFootnotes   = "footnotes"   // 转为 markdown 脚注，来源列表追加在末尾
+)
+
+var citationNumber = regexp.MustCompile(`\d+`)
+
+// 句末标点
+const sentenceEnds = ".!?。！？；;"
+
+type source struct {
+	Title string `json:"providerDisplayName"`
+	Url   string `json:"seeMoreUrl"`
+}
+
+type citations struct {
+	mode    string
+	sources []source
+	cited   []int    // 已引用的序号，按首次出现的顺序
+	pending [][2]int // 已替换、尚未定位的序号及位置
+	last    int      // 上一个引用的位置，引用的范围不越过该位置
+
+	annotations []pkg.Keyv[interface{}]
+}
+
+// 处理方式：<citations mode="xxx"/> 标记 > config.yaml 的 bing.citations
+func newCitations(ctx *gin.Context) *citations {
+	mode := ctx.GetString("citations")
+	if mode == "" {
+		mode = pkg.Config.GetString("bing.citations")
+	}
+
+	switch mode {
+	case citationAnnotations, citationFootnotes:
+	default:
+		mode = citationStrip
+	}
+	return &citations{mode: mode}
+}
+
+// 从 edge-api 的原始消息中读取 sourceAttributions
+func (c *citations) resolve(data []byte) {
+	if c.mode == citationStrip || len(data) == 0 {
+		return
+	}
+
+	type message struct {
+		Author  string   `json:"author"`
+		Sources []source `json:"sourceAttributions"`
+	}
+
+	var response struct {
+		Arguments []struct {
+			Messages []message `json:"messages"`
+		} `json:"arguments"`
+		Item *struct {
+			Messages []message `json:"messages"`
+		} `json:"item"`
+	}
+
+	if err := json.Unmarshal(data, &response); err != nil {
+		return
+	}
+
+	var messages []message
+	for _, argument := range response.Arguments {
+		messages = append(messages, argument.Messages...)
+	}
+	if response.Item != nil {
+		messages = append(messages, response.Item.Messages...)
+	}
+
+	for _, m := range messages {
+		if m.Author == "bot" && len(m.Sources) > 0 {
+			c.sources = m.Sources
+		}
+	}
+}
+
+// 替换 content 中的引用标记
+func (c *citations) replaceAll(re *regexp.Regexp, content string) string {
+	var (
+		buffer strings.Builder
+		last   = 0
+	)
+
+	for _, loc := range re.FindAllStringIndex(content, -1) {
+		buffer.WriteString(content[last:loc[0]])
+		buffer.WriteString(c.replace(content[loc[0]:loc[1]], utf8.RuneCountInString(buffer.String())))
+		last = loc[1]
+	}
+	buffer.WriteString(content[last:])
+	return buffer.String()
+}
+
+// 替换引用标记，offset 为标记在本段输出中的字符位置
+func (c *citations) replace(marker string, offset int) string {
+	if c.mode == citationStrip {
+		return ""
+	}
+
+	number, err := strconv.Atoi(citationNumber.FindString(marker))
+	if err != nil || number <= 0 {
+		return ""
+	}
+
+	if !common.Contains(c.cited, number) {
+		c.cited = append(c.cited, number)
+	}
+
+	if c.mode == citationFootnotes {
+		return fmt.Sprintf("[^%d]", number)
+	}
+
+	c.pending = append(c.pending, [2]int{number, offset})
+	return ""
+}
+
+// 定位已替换的引用，content 为本段之前已输出的内容，raw 为本段输出。
+// 引用的范围为标记之前的一句（到上一个句末标点、换行或上一个引用为止）
+func (c *citations) locate(content, raw string) {
+	if len(c.pending) == 0 {
+		return
+	}
+
+	base := utf8.RuneCountInString(content)
+	text := []rune(content + raw)
+	for _, p := range c.pending {
+		number := p[0]
+		if number > len(c.sources) {
+			continue
+		}
+
+		end := base + p[1]
+		s := c.sources[number-1]
+		c.annotations = append(c.annotations, pkg.Keyv[interface{}]{
+			"type": "url_citation",
+			"url_citation": map[string]interface{}{
+				"start_index": sentenceStart(text, end, c.last),
+				"end_index":   end,
+				"url":         s.Url,
+				"title":       s.Title,
+			},
+		})
+		c.last = end
+	}
+	c.pending = nil
+}
+
+// 返回 end 之前一句的开始位置，不早于 lower
+func sentenceStart(text []rune, end, lower int) int {
+	if end > len(text) {
+		end = len(text)
+	}
+	if lower > end {
+		lower = end
+	}
+
+	pos := end
+	// 跳过标记前的句末标点与空白，如 "Go is fast.[^1^]"
+	for pos > lower && (unicode.IsSpace(text[pos-1]) || strings.ContainsRune(sentenceEnds, text[pos-1])) {
+		pos--
+	}
+	for pos > lower && text[pos-1] != '\n' && !strings.ContainsRune(sentenceEnds, text[pos-1]) {
+		pos--
+	}
+	for pos < end && unicode.IsSpace(text[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// 结束时的处理：annotations 写入 ctx，footnotes 返回追加在末尾的来源列表
+func (c *citations) finish(ctx *gin.Context, content string) string {
+	switch c.mode {
+	case citationAnnotations:
+		c.locate(content, "")
+		if len(c.annotations) > 0 {
+			ctx.Set(vars.GinAnnotations, c.annotations)
+		}
+	case citationFootnotes:
+		var footnotes []string
+		for _, number := range c.cited {
+			if number > len(c.sources) {
+				continue
+			}
+			s := c.sources[number-1]
+			footnotes = append(footnotes, fmt.Sprintf("[^%d]: [%s](%s)", number, s.Title, s.Url))
+		}
+		if len(footnotes) > 0 {
+			return "\n\n" + strings.Join(footnotes, "\n")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package bing
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/bincooo/edge-api"
+	"strings"
+	"testing"
+)
+
+func TestCitations(t *testing.T) {
+	testutil.Config(t)
+	raw := `{"type":1,"target":"update","arguments":[{"messages":[{"author":"bot","text":"","sourceAttributions":[` +
+		`{"providerDisplayName":"Go","seeMoreUrl":"https://go.dev/"},{"providerDisplayName":"Wiki","seeMoreUrl":"https://wikipedia.org/"}]}]}]}`
+
+	complete := func(mode string, sse bool) string {
+		ctx, recorder := testutil.Completion(pkg.ChatCompletion{Model: Model})
+		ctx.Set("citations", mode)
+
+		c := newCitations(ctx)
+		cancel, matchers := joinMatchers(ctx, c, nil)
+		chatResponse := make(chan edge.ChatResponse)
+		go func() {
+			defer close(chatResponse)
+			for _, text := range []string{"Go is fast", "Go is fast[^1^]. It is", "Go is fast[^1^]. It is popular[^2^]."} {
+				chatResponse <- edge.ChatResponse{Text: text, RawData: []byte(raw)}
+			}
+		}()
+		waitResponse(ctx, matchers, c, cancel, chatResponse, sse)
+		return recorder.Body.String()
+	}
+
+	if body := complete("", false); !strings.Contains(body, `"content":"Go is fast. It is popular."`) || strings.Contains(body, "annotations") {
+		t.Fatalf("unexpected strip response: %s", body)
+	}
+
+	body := complete(citationAnnotations, false)
+	for _, expected := range []string{`"content":"Go is fast. It is popular."`, `"url":"https://go.dev/"`, `"title":"Wiki"`,
+		`"end_index":10,"start_index":0`, `"end_index":25,"start_index":12`} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("missing %s: %s", expected, body)
+		}
+	}
+
+	body = complete(citationFootnotes, true)
+	for _, expected := range []string{`"content":"[^1]. It is"`, `[^2]: [Wiki](https://wikipedia.org/)`, "[DONE]"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("missing %s: %s", expected, body)
+		}
+	}
+}
+
+func TestSentenceStart(t *testing.T) {
+	for _, c := range []struct {
+		text            string
+		end, lower, pos int
+	}{
+		{"Go is fast", 10, 0, 0},
+		{"Go is fast.", 11, 0, 0},
+		{"Go is fast. It is popular", 25, 0, 12},
+		{"Go is fast and safe", 19, 10, 11},
+		{"标题\n快速", 5, 0, 3},
+		{"标题\n快速。简单", 8, 0, 6},
+	} {
+		if pos := sentenceStart([]rune(c.text), c.end, c.lower); pos != c.pos {
+			t.Errorf("%q: expected %d, got %d", c.text, c.pos, pos)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package bing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/bincooo/emit.io"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	pollInterval = 3 * time.Second
+
+	imageSrc   = regexp.MustCompile(`src="(https://[^"]+)"`)
+	imageError = regexp.MustCompile(`class="gil_err_mt">([^<]+)<`)
+)
+
+// Bing Image Creator，每次最多生成 4 张，n 超出时多次提交
+func (API) Generation(ctx *gin.Context) {
+	var (
+		cookie     = ctx.GetString("token")
+		proxies    = ctx.GetString("proxies")
+		domain     = pkg.Config.GetString("domain")
+		generation = common.GetGinGeneration(ctx)
+	)
+
+	if domain == "" {
+		domain = fmt.Sprintf("http://127.0.0.1:%d", ctx.GetInt("port"))
+	}
+
+	n := generation.N
+	if n <= 0 {
+		n = 1
+	}
+
+	var images []string
+	for len(images) < n {
+		urls, err := createImages(ctx.Request.Context(), proxies, cookie, generation.Prompt)
+		if err != nil {
+			middle.ErrResponse(ctx, -1, err)
+			return
+		}
+		images = append(images, urls...)
+	}
+
+	var data []map[string]string
+	for _, image := range images[:n] {
+		file, err := common.Download(proxies, image, "jpg")
+		if err != nil {
+			middle.ErrResponse(ctx, -1, err)
+			return
+		}
+		data = append(data, map[string]string{"url": fmt.Sprintf("%s/file/%s", domain, file)})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"created": time.Now().Unix(),
+		"data":    data,
+	})
+}
+
+// 提交绘图并轮询结果，返回图片链接
+func createImages(ctx context.Context, proxies, cookie, prompt string) ([]string, error) {
+	baseUrl := pkg.Config.GetString("bing.image.baseUrl")
+	if baseUrl == "" {
+		baseUrl = "https://www.bing.com"
+	}
+
+	timeout := 3 * time.Minute
+	if pkg.Config.IsSet("bing.image.timeout") {
+		timeout = time.Duration(pkg.Config.GetInt("bing.image.timeout")) * time.Second
+	}
+
+	if cookie != "" && !strings.Contains(cookie, "_U=") {
+		cookie = "_U=" + cookie
+	}
+
+	query := url.QueryEscape(prompt)
+	response, err := emit.ClientBuilder().
+		Proxies(proxies).
+		Context(ctx).
+		POST(baseUrl+"/images/create").
+		Query("q", query).
+		Query("rt", "4").
+		Query("FORM", "GENCRE").
+		Header("Cookie", cookie).
+		Header("Content-Type", "application/x-www-form-urlencoded").
+		Bytes([]byte("q=" + query + "&qs=ds")).
+		DoS(http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	// 提交成功后重定向到带有 id 的页面
+	id := response.Request.URL.Query().Get("id")
+	if id == "" {
+		data, _ := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if matches := imageError.FindSubmatch(data); len(matches) > 1 {
+			return nil, fmt.Errorf("bing image creator: %s", strings.TrimSpace(string(matches[1])))
+		}
+		return nil, errors.New("bing image creator: create failed, please check the cookie")
+	}
+	_ = response.Body.Close()
+
+	timer, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-timer.Done():
+			return nil, errors.New("bing image creator: polling timeout")
+		case <-time.After(pollInterval):
+		}
+
+		response, err = emit.ClientBuilder().
+			Proxies(proxies).
+			Context(timer).
+			GET(baseUrl+"/images/create/async/results/"+id).
+			Query("q", query).
+			Header("Cookie", cookie).
+			DoS(http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+
+		data, e := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if e != nil {
+			return nil, e
+		}
+
+		// 生成中返回空内容
+		if len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+
+		var urls []string
+		for _, matches := range imageSrc.FindAllSubmatch(data, -1) {
+			// 去掉缩略图的尺寸参数
+			u := strings.Split(string(matches[1]), "?w=")[0]
+			if !common.Contains(urls, u) {
+				urls = append(urls, u)
+			}
+		}
+
+		if len(urls) == 0 {
+			if matches := imageError.FindSubmatch(data); len(matches) > 1 {
+				return nil, fmt.Errorf("bing image creator: %s", strings.TrimSpace(string(matches[1])))
+			}
+			return nil, errors.New("bing image creator: no images generated")
+		}
+		return urls, nil
+	}
+}
@@ -0,0 +1,74 @@
+package bing
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGeneration(t *testing.T) {
+	polls := 0
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Cookie"), "_U=cookie") {
+			_, _ = w.Write([]byte(`<div class="gil_err_mt">Sign in</div>`))
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/images/create":
+			http.Redirect(w, r, "/images/create?q=cat&rt=4&FORM=GENCRE&id=abc", http.StatusFound)
+		case r.URL.Path == "/images/create":
+			_, _ = w.Write([]byte("<html></html>"))
+		case r.URL.Path == "/images/create/async/results/abc":
+			if polls++; polls%2 == 1 {
+				return
+			}
+			_, _ = w.Write([]byte(`<img class="mimg" src="` + server.URL + `/th/1?w=270&h=270"/><img class="mimg" src="` + server.URL + `/th/2?w=270&h=270"/>`))
+		case strings.HasPrefix(r.URL.Path, "/th/"):
+			_, _ = w.Write([]byte("jpeg"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := *http.DefaultClient
+	defer func() { *http.DefaultClient = client }()
+	*http.DefaultClient = *server.Client()
+
+	dir, _ := os.Getwd()
+	defer os.Chdir(dir)
+	_ = os.Chdir(t.TempDir())
+
+	interval := pollInterval
+	pollInterval = time.Millisecond
+	t.Cleanup(func() { pollInterval = interval })
+
+	config := testutil.Config(t)
+	config.Set("domain", "http://127.0.0.1:8080")
+	config.Set("bing.image.baseUrl", server.URL)
+
+	generate := func(token string) string {
+		ctx, recorder := testutil.Context(http.MethodPost, "/v1/images/generations")
+		ctx.Set("token", token)
+		ctx.Set(vars.GinGeneration, pkg.ChatGeneration{Model: Model, Prompt: "cat", N: 3})
+		Adapter.Generation(ctx)
+		return recorder.Body.String()
+	}
+
+	body := generate("cookie")
+	if strings.Count(body, `"url":"http://127.0.0.1:8080/file/tmp/`) != 3 || polls != 4 {
+		t.Fatalf("unexpected response (%d polls): %s", polls, body)
+	}
+
+	if body = generate("expired"); !strings.Contains(body, "Sign in") {
+		t.Fatalf("unexpected error: %s", body)
+	}
+}
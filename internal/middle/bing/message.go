@@ -9,7 +9,6 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/edge-api"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"time"
 )
 
@@ -36,20 +35,21 @@ func waitMessage(chatResponse chan edge.ChatResponse, cancel func(str string) bo
 	return content, nil
 }
 
-func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, cancel chan error, chatResponse chan edge.ChatResponse, sse bool) {
+func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, c *citations, cancel chan error, chatResponse chan edge.ChatResponse, sse bool) {
 	var (
 		pos     = 0
 		content = ""
 		created = time.Now().Unix()
 		tokens  = ctx.GetInt("tokens")
+		model   = common.GetGinCompletion(ctx).Model
 	)
 
-	logrus.Info("waitResponse ...")
+	common.Logger(ctx).Info("waitResponse ...")
 	for {
 		select {
 		case err := <-cancel:
 			if err != nil {
-				logrus.Error(err)
+				common.Logger(ctx).Error(err)
 				if middle.NotSSEHeader(ctx) {
 					middle.ErrResponse(ctx, -1, err)
 				}
@@ -57,45 +57,59 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, cancel chan error, c
 			}
 			goto label
 		default:
-			message, ok := <-chatResponse
+			message, ok := middle.Receive(ctx, chatResponse)
+			if middle.IsClosed(ctx) {
+				middle.Abandon(ctx, chatResponse)
+				return
+			}
+
 			if !ok {
 				goto label
 			}
 
 			if message.Error != nil {
-				logrus.Error(message.Error)
+				common.Logger(ctx).Error(message.Error)
 				if middle.NotSSEHeader(ctx) {
 					middle.ErrResponse(ctx, -1, message.Error)
 				}
 				return
 			}
 
+			c.resolve(message.RawData)
 			var raw string
 			contentL := len(message.Text)
 			if pos < contentL {
 				raw = message.Text[pos:contentL]
-				fmt.Printf("----- raw -----\n %s\n", raw)
+				common.LogRaw(ctx, raw)
 			}
 			pos = contentL
 			raw = pkg.ExecMatchers(matchers, raw)
 
 			if sse {
-				middle.SSEResponse(ctx, Model, raw, created)
+				middle.SSEResponse(ctx, model, raw, created)
 			}
+			c.locate(content, raw)
 			content += raw
 		}
 	}
 
 label:
+	if footnotes := c.finish(ctx, content); footnotes != "" {
+		if sse {
+			middle.SSEResponse(ctx, model, footnotes, created)
+		}
+		content += footnotes
+	}
+
 	ctx.Set(vars.GinCompletionUsage, common.CalcUsageTokens(content, tokens))
 	if !sse {
-		middle.Response(ctx, Model, content)
+		middle.Response(ctx, model, content)
 	} else {
-		middle.SSEResponse(ctx, Model, "[DONE]", created)
+		middle.SSEResponse(ctx, model, "[DONE]", created)
 	}
 }
 
-func mergeMessages(pad bool, max int, messages []pkg.Keyv[interface{}]) (pMessages []edge.ChatMessage, text string, tokens int) {
+func mergeMessages(pad, page bool, max int, messages []pkg.Keyv[interface{}]) (pMessages []edge.ChatMessage, text string, tokens int) {
 	condition := func(expr string) string {
 		switch expr {
 		case "system", "user", "function":
@@ -146,6 +160,10 @@ func mergeMessages(pad bool, max int, messages []pkg.Keyv[interface{}]) (pMessag
 		max -= 2
 	}
 
+	if max < 0 {
+		max = 0
+	}
+
 	// 获取最后一条用户消息
 	if pos := len(newMessages) - 1; newMessages[pos]["author"] == "user" {
 		text = newMessages[pos]["text"]
@@ -154,12 +172,14 @@ func mergeMessages(pad bool, max int, messages []pkg.Keyv[interface{}]) (pMessag
 		text = "continue"
 	}
 
-	// 超出最大轮次改为WebPage
+	// 超出最大轮次改为WebPage，关闭时丢弃
 	if len(newMessages)/2 > max {
-		message := edge.BuildPageMessage(common.StringCombiner(newMessages[:len(newMessages)-max*2], func(message edge.ChatMessage) string {
-			return message["text"]
-		}))
-		pMessages = append(pMessages, message)
+		if page {
+			message := edge.BuildPageMessage(common.StringCombiner(newMessages[:len(newMessages)-max*2], func(message edge.ChatMessage) string {
+				return message["text"]
+			}))
+			pMessages = append(pMessages, message)
+		}
 		newMessages = newMessages[len(newMessages)-max*2:]
 	}
 
@@ -1,15 +1,15 @@
 package bing
 
 import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/edge-api"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 )
 
 func completeToolCalls(ctx *gin.Context, cookie, proxies string, completion pkg.ChatCompletion) bool {
-	logrus.Infof("completeTools ...")
+	common.Logger(ctx).Infof("completeTools ...")
 	exec, err := middle.CompleteToolCalls(ctx, completion, func(message string) (string, error) {
 		options, err := edge.NewDefaultOptions(cookie, "")
 		if err != nil {
@@ -0,0 +1,71 @@
+package middle
+
+import (
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/gin-gonic/gin"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	abandonedMu sync.Mutex
+	// 按模型统计被客户端放弃的上游请求数
+	abandoned = make(map[string]int64)
+)
+
+// 客户端是否已断开：请求的 context 被取消，或写出响应失败
+func IsClosed(ctx *gin.Context) bool {
+	return ctx.GetBool(vars.GinClose) || IsCanceled(ctx.Request.Context())
+}
+
+// 读取上游输出，客户端断开时不再阻塞等待，ok 返回 false
+func Receive[T any](ctx *gin.Context, ch chan T) (value T, ok bool) {
+	select {
+	case value, ok = <-ch:
+	case <-ctx.Request.Context().Done():
+	}
+	return
+}
+
+// 客户端已断开：单独记录日志与统计，并在后台读完上游剩余的输出，避免上游协程阻塞。
+// 上游请求使用的 ctx.Request.Context() 会随之取消。
+func Abandon[T any](ctx *gin.Context, ch chan T) {
+	model := common.GetGinCompletion(ctx).Model
+	ctx.Set(vars.GinClose, true)
+	common.Logger(ctx).WithField("abandoned", true).
+		Warnf("client disconnected, upstream request abandoned: %s", model)
+
+	abandonedMu.Lock()
+	abandoned[model]++
+	abandonedMu.Unlock()
+
+	if ch != nil {
+		go func() {
+			for range ch {
+			}
+		}()
+	}
+}
+
+// 统计信息，prometheus 文本格式
+func Metrics() string {
+	abandonedMu.Lock()
+	defer abandonedMu.Unlock()
+
+	models := make([]string, 0, len(abandoned))
+	for model := range abandoned {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+
+	var builder strings.Builder
+	builder.WriteString("# HELP adapter_abandoned_total Upstream requests abandoned because the client disconnected.\n")
+	builder.WriteString("# TYPE adapter_abandoned_total counter\n")
+	for _, model := range models {
+		builder.WriteString(fmt.Sprintf("adapter_abandoned_total{model=%q} %d\n", model, abandoned[model]))
+	}
+	return builder.String()
+}
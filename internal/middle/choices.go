@@ -0,0 +1,179 @@
+package middle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// n > 1 时并发执行 n 次补全，每次补全使用派生的子上下文，
+// 流式响应按 index 交错输出，非流式响应合并为多个 choices，usage 累加。
+//
+//	complete: 单次补全的执行函数
+func CompleteChoices(ctx *gin.Context, completion pkg.ChatCompletion, complete func(ctx *gin.Context, completion pkg.ChatCompletion)) {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+
+		n       = completion.N
+		created = time.Now().Unix()
+		usage   = make(map[string]int)
+
+		// 流式：每个 choice 的结束块，全部完成后再按顺序输出
+		finishes = make([]*pkg.ChatResponse, n)
+		// 非流式：每个 choice 的完整响应
+		results = make([]*pkg.ChatResponse, n)
+
+		errCode int
+		errData interface{}
+
+		children = make([]*gin.Context, n)
+		cancels  = make([]context.CancelFunc, n)
+	)
+
+	cancelAll := func() {
+		for pos := range children {
+			children[pos].Set(vars.GinClose, true)
+			cancels[pos]()
+		}
+	}
+
+	for index := 0; index < n; index++ {
+		pos := index
+		children[pos], cancels[pos] = Fork(ctx, func(code int, data interface{}, sse bool) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if code != http.StatusOK {
+				// 已开始向客户端输出，只能记录
+				if !NotSSEHeader(ctx) {
+					common.Logger(ctx).Errorf("choice[%d] error: %v", pos, data)
+					AuditResponse(ctx, code, data)
+					return
+				}
+				if errData == nil {
+					errCode, errData = code, data
+					cancelAll()
+				}
+				return
+			}
+
+			response, ok := data.(pkg.ChatResponse)
+			if !ok || errData != nil {
+				return // "[DONE]"
+			}
+
+			response.Id = CompletionId(ctx, created)
+			response.Created = created
+			response.Choices = append([]pkg.ChatChoice(nil), response.Choices...)
+			for i := range response.Choices {
+				response.Choices[i].Index = pos
+			}
+
+			for k, v := range response.Usage {
+				usage[k] += v
+			}
+			response.Usage = nil
+
+			if !sse {
+				results[pos] = &response
+				return
+			}
+
+			if len(response.Choices) > 0 && response.Choices[0].FinishReason != nil {
+				finishes[pos] = &response
+				return
+			}
+
+			setSSEHeader(ctx)
+			event(ctx, response)
+			if ctx.GetBool(vars.GinClose) {
+				cancelAll()
+			}
+		})
+	}
+
+	for index := 0; index < n; index++ {
+		wg.Add(1)
+		go func(pos int) {
+			defer wg.Done()
+			defer cancels[pos]()
+			// 子协程不在 gin 的 panicHandler 之内，panic 转为该 choice 的错误
+			defer func() {
+				if r := recover(); r != nil {
+					ErrResponse(children[pos], -1, fmt.Sprintf("%v", r))
+				}
+			}()
+			complete(children[pos], copyCompletion(ctx, completion))
+		}(index)
+	}
+	wg.Wait()
+
+	if errData != nil && NotSSEHeader(ctx) {
+		writeJSON(ctx, errCode, errData)
+		return
+	}
+
+	if completion.Stream {
+		setSSEHeader(ctx)
+		var last *pkg.ChatResponse
+		for _, finish := range finishes {
+			if finish == nil {
+				continue
+			}
+			if last != nil {
+				event(ctx, *last)
+			}
+			last = finish
+		}
+		if last != nil {
+			last.Usage = usage
+			event(ctx, *last)
+		}
+		time.Sleep(100 * time.Millisecond)
+		event(ctx, "[DONE]")
+		return
+	}
+
+	var response *pkg.ChatResponse
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		if response == nil {
+			response = result
+			continue
+		}
+		response.Choices = append(response.Choices, result.Choices...)
+	}
+
+	if response == nil {
+		ErrResponse(ctx, -1, "empty choices")
+		return
+	}
+
+	response.Usage = usage
+	writeJSON(ctx, http.StatusOK, *response)
+}
+
+// 深拷贝，避免并发的补全相互修改 messages、tools
+func copyCompletion(ctx *gin.Context, completion pkg.ChatCompletion) (value pkg.ChatCompletion) {
+	marshal, err := json.Marshal(completion)
+	if err == nil {
+		err = json.Unmarshal(marshal, &value)
+	}
+
+	if err != nil {
+		common.Logger(ctx).Error(err)
+		value = completion
+	}
+	value.N = 1
+	return
+}
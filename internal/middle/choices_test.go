@@ -0,0 +1,150 @@
+package middle
+
+import (
+	"encoding/json"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 每个 choice 回复 "hi"，usage 各计 1+2
+func completeChoice(ctx *gin.Context, completion pkg.ChatCompletion) {
+	ctx.Set(vars.GinCompletionUsage, map[string]int{"prompt_tokens": 1, "completion_tokens": 2, "total_tokens": 3})
+	if !completion.Stream {
+		Response(ctx, "mock", "hi")
+		return
+	}
+	created := time.Now().Unix()
+	SSEResponse(ctx, "mock", "hi", created)
+	SSEResponse(ctx, "mock", "[DONE]", created)
+}
+
+// 解析 SSE 响应，返回数据块及 [DONE] 的次数
+func sseEvents(t *testing.T, body string) (responses []pkg.ChatResponse, done int) {
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done++
+			continue
+		}
+		var response pkg.ChatResponse
+		if err := json.Unmarshal([]byte(data), &response); err != nil {
+			t.Fatalf("unexpected event: %s", data)
+		}
+		responses = append(responses, response)
+	}
+	return
+}
+
+func TestCompleteChoices(t *testing.T) {
+	testutil.Config(t)
+	completion := pkg.ChatCompletion{N: 2, Messages: []pkg.Keyv[interface{}]{{"role": "user", "content": "hi"}}}
+	ctx, recorder := testutil.Completion(completion)
+	CompleteChoices(ctx, completion, completeChoice)
+
+	var response pkg.ChatResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unexpected response: %s", recorder.Body.String())
+	}
+
+	if len(response.Choices) != 2 || response.Usage["total_tokens"] != 6 || response.Usage["completion_tokens"] != 4 {
+		t.Fatalf("unexpected response: %s", recorder.Body.String())
+	}
+	indexes := map[int]bool{}
+	for _, choice := range response.Choices {
+		indexes[choice.Index] = true
+		if choice.Message == nil || choice.Message.Content != "hi" {
+			t.Fatalf("unexpected choice: %s", recorder.Body.String())
+		}
+	}
+	if !indexes[0] || !indexes[1] {
+		t.Fatalf("unexpected indexes: %v", indexes)
+	}
+}
+
+func TestCompleteChoicesStream(t *testing.T) {
+	testutil.Config(t)
+	completion := pkg.ChatCompletion{N: 2, Stream: true, Messages: []pkg.Keyv[interface{}]{{"role": "user", "content": "hi"}}}
+	ctx, recorder := testutil.Completion(completion)
+	CompleteChoices(ctx, completion, completeChoice)
+
+	responses, done := sseEvents(t, recorder.Body.String())
+	if done != 1 {
+		t.Fatalf("expected a single [DONE]: %s", recorder.Body.String())
+	}
+
+	var (
+		contents = map[int]string{}
+		finishes = map[int]bool{}
+		usage    map[string]int
+	)
+	for index, response := range responses {
+		choice := response.Choices[0]
+		if choice.Delta != nil {
+			contents[choice.Index] += choice.Delta.Content
+		}
+		if choice.FinishReason != nil {
+			finishes[choice.Index] = true
+			// 结束块在内容之后统一输出
+			for _, rest := range responses[index+1:] {
+				if rest.Choices[0].FinishReason == nil {
+					t.Fatalf("content after finish chunks: %s", recorder.Body.String())
+				}
+			}
+		}
+		if response.Usage != nil {
+			usage = response.Usage
+		}
+	}
+
+	if contents[0] != "hi" || contents[1] != "hi" || !finishes[0] || !finishes[1] {
+		t.Fatalf("unexpected choices: %v %v", contents, finishes)
+	}
+	if usage["total_tokens"] != 6 {
+		t.Fatalf("unexpected usage: %v", usage)
+	}
+}
+
+func TestCompleteChoicesPanic(t *testing.T) {
+	testutil.Config(t)
+	var (
+		calls atomic.Int32
+		first = make(chan struct{})
+	)
+	// 第一个 choice 正常输出，第二个在其完成后 panic
+	complete := func(ctx *gin.Context, completion pkg.ChatCompletion) {
+		if calls.Add(1) == 1 {
+			completeChoice(ctx, completion)
+			close(first)
+			return
+		}
+		<-first
+		panic("boom")
+	}
+
+	completion := pkg.ChatCompletion{N: 2, Stream: true, Messages: []pkg.Keyv[interface{}]{{"role": "user", "content": "hi"}}}
+	ctx, recorder := testutil.Completion(completion)
+	CompleteChoices(ctx, completion, complete)
+
+	responses, done := sseEvents(t, recorder.Body.String())
+	if done != 1 || len(responses) != 2 || responses[0].Choices[0].Delta.Content != "hi" || responses[1].Choices[0].FinishReason == nil {
+		t.Fatalf("unexpected response: %s", recorder.Body.String())
+	}
+
+	// 尚未输出时 panic 作为请求的错误返回
+	completion.Stream = false
+	ctx, recorder = testutil.Completion(completion)
+	CompleteChoices(ctx, completion, func(ctx *gin.Context, completion pkg.ChatCompletion) { panic("boom") })
+	if recorder.Code != http.StatusInternalServerError || !strings.Contains(recorder.Body.String(), "boom") {
+		t.Fatalf("unexpected response: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
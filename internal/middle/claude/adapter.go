@@ -4,6 +4,7 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
 	claude2 "github.com/bincooo/claude-api"
+	"github.com/bincooo/claude-api/types"
 	"github.com/bincooo/claude-api/vars"
 	"github.com/gin-gonic/gin"
 	"strings"
@@ -31,6 +32,10 @@ func (API) Match(_ *gin.Context, model string) bool {
 	}
 }
 
+func (API) Sampling(*gin.Context, string) []string {
+	return []string{}
+}
+
 func (API) Models() []middle.Model {
 	return []middle.Model{
 		{
@@ -80,19 +85,49 @@ func (API) Completion(ctx *gin.Context) {
 		}
 	}
 
-	attachments, tokens := mergeMessages(completion.Messages)
+	messages := completion.Messages
+	session := loadSession(ctx, cookie, model, completion)
+	if session != nil {
+		defer session.Unlock()
+		messages = session.pending(messages)
+	}
+
+	attachments, tokens := mergeMessages(messages)
 	ctx.Set("tokens", tokens)
-	chat, err := claude2.New(options)
-	if err != nil {
-		middle.ErrResponse(ctx, -1, err)
-		return
+
+	var chat types.Chat
+	if session != nil && session.chat != nil {
+		chat = session.chat
+	} else {
+		newChat, err := claude2.New(options)
+		if err != nil {
+			middle.ErrResponse(ctx, -1, err)
+			return
+		}
+		chat = newChat
+		if session != nil {
+			session.chat = chat
+		}
 	}
 
 	chatResponse, err := chat.Reply(ctx.Request.Context(), "", attachments)
 	if err != nil {
 		middle.ErrResponse(ctx, -1, err)
+		if session != nil {
+			session.release()
+		} else {
+			chat.Delete()
+		}
 		return
 	}
-	defer chat.Delete()
-	waitResponse(ctx, matchers, chatResponse, completion.Stream)
+
+	content, ok := waitResponse(ctx, matchers, chatResponse, completion.Stream)
+	switch {
+	case session == nil:
+		chat.Delete()
+	case ok:
+		session.update(completion.Messages, content)
+	default:
+		session.release()
+	}
 }
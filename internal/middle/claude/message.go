@@ -9,9 +9,9 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/claude-api/types"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 func waitMessage(chatResponse chan types.PartialResponse, cancel func(str string) bool) (content string, err error) {
@@ -37,29 +37,34 @@ func waitMessage(chatResponse chan types.PartialResponse, cancel func(str string
 	return content, nil
 }
 
-func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, chatResponse chan types.PartialResponse, sse bool) {
+// 返回完整的回复内容，中断或出错时 ok 为 false
+func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, chatResponse chan types.PartialResponse, sse bool) (content string, ok bool) {
 	var (
-		content = ""
 		created = time.Now().Unix()
 		tokens  = ctx.GetInt("tokens")
 	)
-	logrus.Infof("waitResponse ...")
+	common.Logger(ctx).Infof("waitResponse ...")
 
 	for {
-		message, ok := <-chatResponse
-		if !ok {
+		message, received := middle.Receive(ctx, chatResponse)
+		if middle.IsClosed(ctx) {
+			middle.Abandon(ctx, chatResponse)
+			return
+		}
+
+		if !received {
 			break
 		}
 
 		if message.Error != nil {
-			logrus.Error(message.Error)
+			common.Logger(ctx).Error(message.Error)
 			if middle.NotSSEHeader(ctx) {
 				middle.ErrResponse(ctx, -1, message.Error)
 			}
 			return
 		}
 
-		fmt.Printf("----- raw -----\n %s\n", message.Text)
+		common.LogRaw(ctx, message.Text)
 		raw := pkg.ExecMatchers(matchers, message.Text)
 		if sse {
 			middle.SSEResponse(ctx, Model, raw, created)
@@ -73,9 +78,11 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, chatResponse chan ty
 	} else {
 		middle.SSEResponse(ctx, Model, "[DONE]", created)
 	}
+	return content, true
 }
 
-func mergeMessages(messages []pkg.Keyv[interface{}]) (attachment []types.Attachment, tokens int) {
+// 合并历史对话，按 claude.attachment.size 拆分为多个附件，按 claude.pad 填充
+func mergeMessages(messages []pkg.Keyv[interface{}]) (attachments []types.Attachment, tokens int) {
 	condition := func(expr string) string {
 		switch expr {
 		case "system", "function", "assistant":
@@ -90,7 +97,6 @@ func mergeMessages(messages []pkg.Keyv[interface{}]) (attachment []types.Attachm
 	// 合并历史对话
 	nMessages := common.MessageCombiner(messages, func(previous, next string, message map[string]string, buffer *bytes.Buffer) []string {
 		role := message["role"]
-		tokens += common.CalcTokens(message["content"])
 		if condition(role) == condition(next) {
 			// cache buffer
 			if role == "function" {
@@ -108,16 +114,102 @@ func mergeMessages(messages []pkg.Keyv[interface{}]) (attachment []types.Attachm
 		}
 	})
 
-	join := strings.Join(nMessages, "\n\n")
-	join = common.PadText(padMaxCount-len(join), join)
+	for _, content := range splitMessages(nMessages, pkg.Config.GetInt("claude.attachment.size")) {
+		tokens += common.CalcTokens(content)
+		attachments = append(attachments, newAttachment(len(attachments), content))
+	}
+
+	if len(attachments) > 0 {
+		content := padText(attachments[0].Content, totalSize(attachments))
+		attachments[0] = newAttachment(0, content)
+	}
+	return
+}
+
+// 按消息边界拆分，每段不超过 size 个字符，单条消息超出时按字符截断；size <= 0 时不拆分
+func splitMessages(messages []string, size int) (values []string) {
+	if size <= 0 {
+		return []string{strings.Join(messages, "\n\n")}
+	}
+
+	var buffer strings.Builder
+	flush := func() {
+		if buffer.Len() > 0 {
+			values = append(values, buffer.String())
+			buffer.Reset()
+		}
+	}
+
+	for _, message := range messages {
+		if buffer.Len() > 0 && buffer.Len()+2+len(message) > size {
+			flush()
+		}
 
-	tokens = common.CalcTokens(join)
-	attachment = append(attachment, types.Attachment{
-		Content:  join,
-		FileName: "paste.txt",
-		FileSize: len(join),
+		for len(message) > size {
+			flush()
+			// 避免截断多字节字符
+			cut := size
+			for cut > 0 && !utf8.RuneStart(message[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				cut = size
+			}
+			values = append(values, message[:cut])
+			message = message[cut:]
+		}
+
+		if buffer.Len() > 0 {
+			buffer.WriteString("\n\n")
+		}
+		buffer.WriteString(message)
+	}
+
+	flush()
+	if len(values) == 0 {
+		values = append(values, "")
+	}
+	return
+}
+
+func newAttachment(index int, content string) types.Attachment {
+	name := "paste.txt"
+	if index > 0 {
+		name = fmt.Sprintf("paste-%d.txt", index+1)
+	}
+	return types.Attachment{
+		Content:  content,
+		FileName: name,
+		FileSize: len(content),
 		FileType: "text/plain",
-	})
+	}
+}
 
+func totalSize(attachments []types.Attachment) (size int) {
+	for _, attachment := range attachments {
+		size += len(attachment.Content)
+	}
 	return
 }
+
+// 填充到 claude.pad.size 个字符（默认 25000），mode: random 随机字符（默认）、space 空白、none 不填充
+//
+//	size: 当前附件的总长度
+func padText(content string, size int) string {
+	padSize := padMaxCount
+	if pkg.Config.IsSet("claude.pad.size") {
+		padSize = pkg.Config.GetInt("claude.pad.size")
+	}
+
+	switch pkg.Config.GetString("claude.pad.mode") {
+	case "none":
+		return content
+	case "space":
+		if length := padSize - size; length > 0 {
+			return strings.Repeat(" ", length) + "\n------\n\n" + content
+		}
+		return content
+	default:
+		return common.PadText(padSize-size, content)
+	}
+}
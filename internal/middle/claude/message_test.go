@@ -0,0 +1,168 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/bincooo/claude-api/types"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestMergeMessagesSplit(t *testing.T) {
+	config := testutil.Config(t)
+	config.Set("claude.attachment.size", 20)
+	config.Set("claude.pad.mode", "none")
+
+	attachments, _ := mergeMessages([]pkg.Keyv[interface{}]{
+		{"role": "user", "content": "hello"},
+		{"role": "assistant", "content": "hi"},
+		{"role": "user", "content": strings.Repeat("长", 10)},
+	})
+
+	// 超长的消息按字符截断为多个附件
+	if len(attachments) != 5 {
+		t.Fatalf("unexpected attachments: %v", attachments)
+	}
+	if attachments[0].FileName != "paste.txt" || attachments[4].FileName != "paste-5.txt" {
+		t.Fatalf("unexpected file names: %s %s", attachments[0].FileName, attachments[4].FileName)
+	}
+	for _, attachment := range attachments {
+		if len(attachment.Content) > 20 || !utf8.ValidString(attachment.Content) {
+			t.Fatalf("unexpected attachment: %q", attachment.Content)
+		}
+	}
+}
+
+func TestPadText(t *testing.T) {
+	config := testutil.Config(t)
+	if content := padText("hi", 2); len(content) < padMaxCount-100 {
+		t.Fatalf("unexpected default padding: %d", len(content))
+	}
+
+	config.Set("claude.pad.mode", "space")
+	config.Set("claude.pad.size", 100)
+	if content := padText("hi", 2); len(content) != 98+len("\n------\n\n")+2 {
+		t.Fatalf("unexpected space padding: %d", len(content))
+	}
+
+	config.Set("claude.pad.mode", "none")
+	if content := padText("hi", 2); content != "hi" {
+		t.Fatalf("unexpected padding: %q", content)
+	}
+}
+
+func TestSessionPending(t *testing.T) {
+	testutil.Config(t)
+	messages := []pkg.Keyv[interface{}]{
+		{"role": "system", "content": "be brief"},
+		{"role": "user", "content": "hi"},
+	}
+
+	s := &session{}
+	s.update(messages, "hello")
+	s.chat = &mockChat{}
+
+	next := append(messages, pkg.Keyv[interface{}]{"role": "assistant", "content": "hello "}, pkg.Keyv[interface{}]{"role": "user", "content": "again"})
+	if pending := s.pending(next); len(pending) != 1 || pending[0].GetString("content") != "again" {
+		t.Fatalf("unexpected pending: %v", pending)
+	}
+
+	chat := s.chat.(*mockChat)
+	edited := []pkg.Keyv[interface{}]{{"role": "user", "content": "edited"}, {"role": "assistant", "content": "hello"}, {"role": "user", "content": "again"}}
+	if pending := s.pending(edited); len(pending) != 3 || s.chat != nil || !chat.deleted {
+		t.Fatalf("expected a new conversation: %v", pending)
+	}
+}
+
+func TestLoadSession(t *testing.T) {
+	config := testutil.Config(t)
+	config.Set("claude.session.enabled", true)
+
+	saved := sessions
+	sessions = make(map[string]*session)
+	t.Cleanup(func() { sessions = saved })
+
+	load := func(id, user string) *session {
+		ctx, _ := testutil.Completion(pkg.ChatCompletion{})
+		if id != "" {
+			ctx.Request.Header.Set("X-Session-Id", id)
+		}
+		s := loadSession(ctx, "cookie", "claude-2", pkg.ChatCompletion{User: user})
+		if s != nil {
+			s.Unlock()
+		}
+		return s
+	}
+
+	first := load("a", "")
+	if first == nil || load("a", "") != first || load("b", "") == first {
+		t.Fatal("expected the same session for the same id")
+	}
+
+	// user 字段需开启 claude.session.user
+	if load("", "u") != nil {
+		t.Fatal("unexpected session from the user field")
+	}
+	config.Set("claude.session.user", true)
+	if load("", "u") == nil {
+		t.Fatal("expected a session from the user field")
+	}
+
+	// 过期的会话（包括没有对话的）在下次获取时清理
+	config.Set("claude.session.ttl", 0)
+	load("a", "")
+	time.Sleep(time.Millisecond)
+	load("c", "")
+	smu.Lock()
+	defer smu.Unlock()
+	if _, ok := sessions["cookie:claude-2:a"]; ok {
+		t.Fatal("expected the expired session to be removed")
+	}
+	if _, ok := sessions["cookie:claude-2:b"]; !ok {
+		t.Fatal("unexpected removal of a live session")
+	}
+}
+
+func TestLoadSessionConcurrent(t *testing.T) {
+	config := testutil.Config(t)
+	config.Set("claude.session.enabled", true)
+	config.Set("claude.session.ttl", 0)
+
+	saved := sessions
+	sessions = make(map[string]*session)
+	t.Cleanup(func() { sessions = saved })
+
+	var wg sync.WaitGroup
+	for index := 0; index < 8; index++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			for count := 0; count < 50; count++ {
+				ctx, _ := testutil.Completion(pkg.ChatCompletion{})
+				ctx.Request.Header.Set("X-Session-Id", fmt.Sprintf("%d", (index+count)%4))
+				s := loadSession(ctx, "cookie", "claude-2", pkg.ChatCompletion{})
+				s.chat = &mockChat{}
+				s.update([]pkg.Keyv[interface{}]{{"role": "user", "content": "hi"}}, "hello")
+				s.Unlock()
+			}
+		}(index)
+	}
+	wg.Wait()
+}
+
+type mockChat struct {
+	deleted bool
+}
+
+func (*mockChat) NewChannel(string) error { return nil }
+
+func (*mockChat) Reply(context.Context, string, []types.Attachment) (chan types.PartialResponse, error) {
+	return nil, nil
+}
+
+func (c *mockChat) Delete() { c.deleted = true }
@@ -0,0 +1,133 @@
+package claude
+
+import (
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/bincooo/claude-api/types"
+	"github.com/gin-gonic/gin"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// 会话按 cookie、模型与会话标识索引，expires 只在持有 smu 时读写
+	sessions = make(map[string]*session)
+	smu      sync.Mutex
+)
+
+// 网页版会话：同一客户端会话复用 claude 对话，只发送新增的消息
+type session struct {
+	sync.Mutex
+	chat    types.Chat
+	hash    uint32 // 已发送的消息及回复的 hash
+	count   int    // 已发送的消息及回复的条数
+	expires time.Time
+	closed  bool
+}
+
+// 会话标识取自请求头 X-Session-Id，开启 claude.session.user 时也可取自请求的 user 字段，
+// 未开启 claude.session.enabled 时返回 nil
+func loadSession(ctx *gin.Context, cookie, model string, completion pkg.ChatCompletion) *session {
+	if !pkg.Config.GetBool("claude.session.enabled") {
+		return nil
+	}
+
+	id := ctx.GetHeader("X-Session-Id")
+	if id == "" && pkg.Config.GetBool("claude.session.user") {
+		id = completion.User
+	}
+	if id == "" {
+		return nil
+	}
+
+	key := fmt.Sprintf("%s:%s:%s", cookie, model, id)
+	for {
+		now := time.Now()
+		smu.Lock()
+		// 清理过期的会话，正在使用的会话跳过
+		for k, s := range sessions {
+			if k != key && now.After(s.expires) && s.TryLock() {
+				delete(sessions, k)
+				s.closed = true
+				go func(s *session) { s.release(); s.Unlock() }(s)
+			}
+		}
+
+		s, ok := sessions[key]
+		if !ok {
+			s = &session{expires: now.Add(sessionTTL())}
+			sessions[key] = s
+		}
+		smu.Unlock()
+
+		s.Lock()
+		// 等待期间已被清理，重新获取
+		if s.closed {
+			s.Unlock()
+			continue
+		}
+
+		// 使用时顺延过期时间，请求失败未记录回复的会话也会按 ttl 清理
+		smu.Lock()
+		expired := now.After(s.expires)
+		s.expires = now.Add(sessionTTL())
+		smu.Unlock()
+
+		if expired {
+			s.release()
+		}
+		return s
+	}
+}
+
+// 对话保留的时间：claude.session.ttl 秒，默认 1800
+func sessionTTL() time.Duration {
+	ttl := 1800
+	if pkg.Config.IsSet("claude.session.ttl") {
+		ttl = pkg.Config.GetInt("claude.session.ttl")
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// 请求的消息以上次发送的消息及回复开头时返回新增的部分，否则丢弃旧的对话
+func (s *session) pending(messages []pkg.Keyv[interface{}]) []pkg.Keyv[interface{}] {
+	if s.chat != nil && len(messages) > s.count && hashMessages(messages[:s.count]) == s.hash {
+		return messages[s.count:]
+	}
+	s.release()
+	return messages
+}
+
+// 记录本次请求的消息及回复，保留对话到 claude.session.ttl 秒后
+func (s *session) update(messages []pkg.Keyv[interface{}], content string) {
+	messages = append(messages[:len(messages):len(messages)], pkg.Keyv[interface{}]{"role": "assistant", "content": content})
+	s.hash = hashMessages(messages)
+	s.count = len(messages)
+
+	smu.Lock()
+	s.expires = time.Now().Add(sessionTTL())
+	smu.Unlock()
+}
+
+// 删除对话
+func (s *session) release() {
+	if s.chat != nil {
+		s.chat.Delete()
+	}
+	s.chat = nil
+	s.hash = 0
+	s.count = 0
+}
+
+func hashMessages(messages []pkg.Keyv[interface{}]) uint32 {
+	var builder strings.Builder
+	for _, message := range messages {
+		builder.WriteString(message.GetString("role"))
+		builder.WriteString(": ")
+		builder.WriteString(strings.TrimSpace(message.GetString("content")))
+		builder.WriteString("\n")
+	}
+	return common.Hash(builder.String())
+}
@@ -8,12 +8,11 @@ import (
 	"github.com/bincooo/claude-api/types"
 	"github.com/bincooo/claude-api/vars"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"strings"
 )
 
 func completeToolCalls(ctx *gin.Context, cookie, proxies string, completion pkg.ChatCompletion) bool {
-	logrus.Infof("completeTools ...")
+	common.Logger(ctx).Infof("completeTools ...")
 	exec, err := middle.CompleteToolCalls(ctx, completion, func(message string) (string, error) {
 		model := vars.Model4WebClaude2
 		if strings.HasPrefix(completion.Model, "claude-") {
@@ -28,15 +27,8 @@ func completeToolCalls(ctx *gin.Context, cookie, proxies string, completion pkg.
 			return "", err
 		}
 
-		message = common.PadText(padMaxCount-len(message), message)
-		chatResponse, err := chat.Reply(ctx.Request.Context(), "", []types.Attachment{
-			{
-				Content:  message,
-				FileName: "paste.txt",
-				FileSize: len(message),
-				FileType: "text/plain",
-			},
-		})
+		message = padText(message, len(message))
+		chatResponse, err := chat.Reply(ctx.Request.Context(), "", []types.Attachment{newAttachment(0, message)})
 		if err != nil {
 			return "", err
 		}
@@ -30,6 +30,13 @@ func (API) Match(_ *gin.Context, model string) bool {
 	}
 }
 
+func (API) Sampling(ctx *gin.Context, _ string) []string {
+	if ctx.GetBool("notebook") {
+		return []string{"temperature", "top_k", "max_tokens"}
+	}
+	return []string{"temperature", "seed", "presence_penalty", "frequency_penalty"}
+}
+
 func (API) Models() []middle.Model {
 	return []middle.Model{
 		{
@@ -103,9 +110,19 @@ func (API) Completion(ctx *gin.Context) {
 		ctx.Set("tokens", tokens)
 		chat = cohere.New(cookie, completion.Temperature, completion.Model, true)
 		chat.Proxies(proxies)
+		if completion.Seed != nil {
+			chat.Seed(*completion.Seed)
+		}
 	}
 
-	chatResponse, err := chat.Reply(ctx.Request.Context(), pMessages, system, message)
+	var chatResponse chan string
+	var err error
+	if docs := documents(ctx, completion); !notebook && needToFetch(completion, docs) {
+		payload := newPayload(completion, pMessages, system, message, docs)
+		chatResponse, err = fetch(ctx.Request.Context(), proxies, cookie, payload)
+	} else {
+		chatResponse, err = chat.Reply(ctx.Request.Context(), pMessages, system, message)
+	}
 	if err != nil {
 		middle.ErrResponse(ctx, -1, err)
 		return
@@ -0,0 +1,78 @@
+package coh
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNewPayload(t *testing.T) {
+	testutil.Config(t).Set("cohere.connectors", []string{"web-search"})
+
+	ctx, _ := testutil.Context(http.MethodPost, "/v1/chat/completions")
+	ctx.Set("documents", []pkg.Keyv[interface{}]{{"title": "Go", "snippet": "Go is fast."}})
+	completion := pkg.ChatCompletion{
+		Model:     "command-r",
+		Documents: []pkg.Keyv[interface{}]{{"id": "doc_a", "year": 2009}},
+	}
+
+	docs := documents(ctx, completion)
+	if len(docs) != 2 || docs[0]["year"] != "2009" || docs[1]["title"] != "Go" {
+		t.Fatalf("unexpected documents: %v", docs)
+	}
+
+	payload := newPayload(completion, nil, "", "hi", docs)
+	if c := payload["connectors"].([]map[string]string); len(c) != 1 || c[0]["id"] != "web-search" {
+		t.Fatalf("unexpected connectors: %v", payload["connectors"])
+	}
+	if len(payload["documents"].([]map[string]string)) != 2 {
+		t.Fatalf("unexpected payload: %v", payload)
+	}
+}
+
+func TestPenalties(t *testing.T) {
+	testutil.Config(t)
+	completion := pkg.ChatCompletion{Model: "command-r"}
+	if needToFetch(completion, nil) {
+		t.Fatal("plain chats should use cohere-api")
+	}
+
+	// penalties 只能通过 /v1/chat 传入
+	completion.PresencePenalty = 0.5
+	completion.FrequencyPenalty = 0.2
+	if !needToFetch(completion, nil) {
+		t.Fatal("penalties should be sent to /v1/chat")
+	}
+
+	payload := newPayload(completion, nil, "", "hi", nil)
+	if payload["presence_penalty"] != float32(0.5) || payload["frequency_penalty"] != float32(0.2) {
+		t.Fatalf("unexpected payload: %v", payload)
+	}
+}
+
+func TestWaitResponseAnnotations(t *testing.T) {
+	testutil.Config(t)
+	ctx, recorder := testutil.Completion(pkg.ChatCompletion{Model: "command-r"})
+
+	ch := make(chan string)
+	go resolve(ch, &http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{"is_finished":false,"event_type":"stream-start"}` + "\n" +
+			`{"is_finished":false,"event_type":"text-generation","text":"Go is fast."}` + "\n" +
+			`{"is_finished":true,"event_type":"stream-end","response":{"text":"Go is fast.",` +
+			`"citations":[{"start":0,"end":2,"text":"Go","document_ids":["web_0","doc_0"]}],` +
+			`"documents":[{"id":"web_0","title":"Go","url":"https://go.dev/"},{"id":"doc_0","title":"notes"}],` +
+			`"search_results":[{"search_query":{"text":"go language"},"connector":{"id":"web-search"},"document_ids":["web_0"]}]}}` + "\n")),
+	})
+	waitResponse(ctx, nil, ch, false)
+
+	body := recorder.Body.String()
+	for _, expected := range []string{`"content":"Go is fast."`, `"type":"url_citation"`, `"url":"https://go.dev/"`, `"document_id":"doc_0"`, `"query":"go language"`} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("missing %s: %s", expected, body)
+		}
+	}
+}
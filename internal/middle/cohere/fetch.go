@@ -0,0 +1,256 @@
+package coh
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/bincooo/cohere-api"
+	"github.com/bincooo/emit.io"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+)
+
+// stream-end 中的完整响应，只读取引用相关的字段
+type ragResponse struct {
+	Citations []struct {
+		Start       int      `json:"start"`
+		End         int      `json:"end"`
+		Text        string   `json:"text"`
+		DocumentIds []string `json:"document_ids"`
+	} `json:"citations"`
+	Documents     []pkg.Keyv[interface{}] `json:"documents"`
+	SearchResults []struct {
+		SearchQuery struct {
+			Text string `json:"text"`
+		} `json:"search_query"`
+		Connector struct {
+			Id string `json:"id"`
+		} `json:"connector"`
+		DocumentIds []string `json:"document_ids"`
+	} `json:"search_results"`
+}
+
+// 请求体的 documents 与 <document/> 标记合并，cohere 要求字段值均为字符串
+func documents(ctx *gin.Context, completion pkg.ChatCompletion) (values []map[string]string) {
+	docs := completion.Documents
+	if value, ok := ctx.Get("documents"); ok {
+		if flags, o := value.([]pkg.Keyv[interface{}]); o {
+			docs = append(docs, flags...)
+		}
+	}
+
+	for _, doc := range docs {
+		value := make(map[string]string)
+		for k, v := range doc {
+			switch v := v.(type) {
+			case string:
+				value[k] = v
+			case nil:
+			default:
+				bytes, _ := json.Marshal(v)
+				value[k] = string(bytes)
+			}
+		}
+		if len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return
+}
+
+// config.yaml 中的 cohere.connectors，如 web-search
+func connectors() (values []map[string]string) {
+	for _, id := range pkg.Config.GetStringSlice("cohere.connectors") {
+		values = append(values, map[string]string{"id": id})
+	}
+	return
+}
+
+// cohere-api 不支持 documents、connectors 及 presence_penalty、frequency_penalty，携带时直接请求 /v1/chat
+func needToFetch(completion pkg.ChatCompletion, docs []map[string]string) bool {
+	return len(docs) > 0 || len(connectors()) > 0 || completion.PresencePenalty != 0 || completion.FrequencyPenalty != 0
+}
+
+// 构建 /v1/chat 的请求体，与 cohere-api 保持一致，额外携带 documents、connectors 和 penalties；
+// cohere 的 penalty 取值为 0~1，超出范围时由上游返回错误
+func newPayload(completion pkg.ChatCompletion, pMessages []cohere.Message, system, message string, docs []map[string]string) map[string]interface{} {
+	history := make([]map[string]string, 0)
+	for _, m := range pMessages {
+		history = append(history, map[string]string{
+			"role":    m.Role,
+			"message": m.Message,
+		})
+	}
+
+	payload := map[string]interface{}{
+		"chat_history":      history,
+		"message":           message,
+		"model":             completion.Model,
+		"preamble":          system,
+		"prompt_truncation": "OFF",
+		"stream":            true,
+		"temperature":       completion.Temperature,
+	}
+
+	if len(docs) > 0 {
+		payload["documents"] = docs
+	}
+	if c := connectors(); len(c) > 0 {
+		payload["connectors"] = c
+	}
+	if completion.Seed != nil && *completion.Seed > 0 {
+		payload["seed"] = *completion.Seed
+	}
+	if completion.PresencePenalty != 0 {
+		payload["presence_penalty"] = completion.PresencePenalty
+	}
+	if completion.FrequencyPenalty != 0 {
+		payload["frequency_penalty"] = completion.FrequencyPenalty
+	}
+	return payload
+}
+
+// cohere-api 不支持的参数直接请求 /v1/chat；
+// 返回与 cohere-api 相同格式的消息，引用在结束时以 "annotations: " 返回
+func fetch(ctx context.Context, proxies, token string, payload map[string]interface{}) (chan string, error) {
+	baseUrl := pkg.Config.GetString("cohere.baseUrl")
+	if baseUrl == "" {
+		baseUrl = "https://api.cohere.ai"
+	}
+
+	marshal, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := emit.ClientBuilder().
+		Proxies(proxies).
+		Context(ctx).
+		POST(baseUrl+"/v1/chat").
+		Header("Authorization", "Bearer "+token).
+		JHeader().
+		Bytes(marshal).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if len(data) > 0 {
+			return nil, fmt.Errorf("%s: %s", response.Status, data)
+		}
+		return nil, fmt.Errorf("%s", response.Status)
+	}
+
+	ch := make(chan string)
+	go resolve(ch, response)
+	return ch, nil
+}
+
+func resolve(ch chan string, response *http.Response) {
+	defer close(ch)
+	defer response.Body.Close()
+
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var event struct {
+			Event    string          `json:"event_type"`
+			Text     string          `json:"text"`
+			Response json.RawMessage `json:"response"`
+		}
+		if err := json.Unmarshal(line, &event); err != nil {
+			ch <- fmt.Sprintf("error: %v", err)
+			return
+		}
+
+		switch event.Event {
+		case "text-generation":
+			ch <- "text: " + event.Text
+		case "stream-end":
+			var r ragResponse
+			if len(event.Response) == 0 || json.Unmarshal(event.Response, &r) != nil {
+				return
+			}
+			if values := annotations(r); len(values) > 0 {
+				bytes, _ := json.Marshal(values)
+				ch <- "annotations: " + string(bytes)
+			}
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		ch <- fmt.Sprintf("error: %v", err)
+	}
+}
+
+// 引用转为 annotations：有 url 的文档为 url_citation，否则为 document_citation；检索结果为 search_result
+func annotations(r ragResponse) (values []pkg.Keyv[interface{}]) {
+	docs := make(map[string]pkg.Keyv[interface{}])
+	for _, doc := range r.Documents {
+		docs[doc.GetString("id")] = doc
+	}
+
+	for _, citation := range r.Citations {
+		for _, id := range citation.DocumentIds {
+			doc, ok := docs[id]
+			if ok && doc.GetString("url") != "" {
+				values = append(values, pkg.Keyv[interface{}]{
+					"type": "url_citation",
+					"url_citation": map[string]interface{}{
+						"start_index": citation.Start,
+						"end_index":   citation.End,
+						"url":         doc.GetString("url"),
+						"title":       doc.GetString("title"),
+					},
+				})
+				continue
+			}
+
+			values = append(values, pkg.Keyv[interface{}]{
+				"type": "document_citation",
+				"document_citation": map[string]interface{}{
+					"start_index": citation.Start,
+					"end_index":   citation.End,
+					"text":        citation.Text,
+					"document_id": id,
+					"title":       doc.GetString("title"),
+				},
+			})
+		}
+	}
+
+	for _, result := range r.SearchResults {
+		var results []map[string]string
+		for _, id := range result.DocumentIds {
+			doc := docs[id]
+			results = append(results, map[string]string{
+				"id":      id,
+				"title":   doc.GetString("title"),
+				"url":     doc.GetString("url"),
+				"snippet": doc.GetString("snippet"),
+			})
+		}
+
+		values = append(values, pkg.Keyv[interface{}]{
+			"type": "search_result",
+			"search_result": map[string]interface{}{
+				"query":     result.SearchQuery.Text,
+				"connector": result.Connector.Id,
+				"documents": results,
+			},
+		})
+	}
+	return
+}
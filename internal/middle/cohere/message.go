@@ -2,6 +2,7 @@ package coh
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
@@ -10,7 +11,6 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/cohere-api"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"strings"
 	"time"
 )
@@ -42,31 +42,45 @@ func waitMessage(chatResponse chan string, cancel func(str string) bool) (conten
 func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, chatResponse chan string, sse bool) {
 	content := ""
 	created := time.Now().Unix()
-	logrus.Infof("waitResponse ...")
+	common.Logger(ctx).Infof("waitResponse ...")
 	tokens := ctx.GetInt("tokens")
 
 	for {
-		raw, ok := <-chatResponse
+		raw, ok := middle.Receive(ctx, chatResponse)
+		if middle.IsClosed(ctx) {
+			middle.Abandon(ctx, chatResponse)
+			return
+		}
+
 		if !ok {
 			break
 		}
 
 		if strings.HasPrefix(raw, "error: ") {
 			err := strings.TrimPrefix(raw, "error: ")
-			logrus.Error(err)
+			common.Logger(ctx).Error(err)
 			if middle.NotSSEHeader(ctx) {
 				middle.ErrResponse(ctx, -1, err)
 			}
 			return
 		}
 
+		// RAG 的引用及检索结果
+		if strings.HasPrefix(raw, "annotations: ") {
+			var annotations []pkg.Keyv[interface{}]
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(raw, "annotations: ")), &annotations); err == nil {
+				ctx.Set(vars.GinAnnotations, annotations)
+			}
+			continue
+		}
+
 		raw = strings.TrimPrefix(raw, "text: ")
 		contentL := len(raw)
 		if contentL <= 0 {
 			continue
 		}
 
-		fmt.Printf("----- raw -----\n %s\n", raw)
+		common.LogRaw(ctx, raw)
 		raw = pkg.ExecMatchers(matchers, raw)
 		if sse {
 			middle.SSEResponse(ctx, Model, raw, created)
@@ -1,17 +1,17 @@
 package coh
 
 import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/cohere-api"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"net/http"
 	"strings"
 )
 
 func completeToolCalls(ctx *gin.Context, cookie, proxies string, completion pkg.ChatCompletion) bool {
-	logrus.Infof("completeTools ...")
+	common.Logger(ctx).Infof("completeTools ...")
 	exec, err := middle.CompleteToolCalls(ctx, completion, func(message string) (string, error) {
 		pMessages := make([]cohere.Message, 0)
 		chat := cohere.New(cookie, 0.4, completion.Model, false)
@@ -0,0 +1,342 @@
+package middle
+
+import (
+	"bytes"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/agent"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 续写时缓存的开头长度，用于去除与已输出内容重叠的部分
+const continueHeadSize = 64
+
+var fenceOpener = regexp.MustCompile("^\\s*```[\\w+-]+[ \\t]*\n")
+
+// 是否开启自动续写：continue.rounds > 0 且模型匹配 continue.models（为空时匹配全部）
+func NeedToContinue(completion pkg.ChatCompletion) bool {
+	if pkg.Config.GetInt("continue.rounds") <= 0 {
+		return false
+	}
+
+	models := pkg.Config.GetStringSlice("continue.models")
+	if len(models) == 0 {
+		return true
+	}
+
+	for _, pattern := range models {
+		if matched, _ := path.Match(pattern, completion.Model); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// 自动续写：回复被截断时（finish_reason 为 length、代码块未闭合、或命中 continue.patterns），
+// 携带已输出的内容追加一轮 continue 对话，续写内容拼接到同一个响应中，最多 continue.rounds 轮。
+//
+//	complete: 单次补全的执行函数
+func CompleteContinue(ctx *gin.Context, completion pkg.ChatCompletion, complete func(ctx *gin.Context, completion pkg.ChatCompletion)) {
+	var (
+		rounds  = pkg.Config.GetInt("continue.rounds")
+		created = time.Now().Unix()
+		usage   = make(map[string]int)
+
+		model       = completion.Model
+		content     strings.Builder
+		reasoning   strings.Builder
+		annotations []pkg.Keyv[interface{}]
+		finish      string
+
+		base = completion
+	)
+
+	emit := func(str, think string) {
+		if str == "" && think == "" {
+			return
+		}
+		content.WriteString(str)
+		if think != "" {
+			ctx.Set(vars.GinReasoning, bytes.NewBufferString(think))
+		}
+		SSEResponse(ctx, model, str, created)
+	}
+
+	for round := 0; ; round++ {
+		var (
+			code        = http.StatusOK
+			data        interface{}
+			passthrough bool
+			head        strings.Builder
+			flushed     = round == 0
+
+			// 本轮内容在拼接结果中的偏移：之前的内容长度减去去重删除的开头
+			offset = utf8.RuneCountInString(content.String())
+			cited  []pkg.Keyv[interface{}]
+		)
+		finish = ""
+
+		value := copyCompletion(ctx, base)
+		if round > 0 {
+			value.Tools = nil
+			value.Messages = append(value.Messages,
+				pkg.Keyv[interface{}]{"role": "assistant", "content": content.String()},
+				pkg.Keyv[interface{}]{"role": "user", "content": continueMessage(ctx, content.String())})
+		}
+
+		var child *gin.Context
+		child, cancel := Fork(ctx, func(c int, d interface{}, sse bool) {
+			if c != http.StatusOK {
+				code, data = c, d
+				return
+			}
+
+			if passthrough {
+				event(ctx, d)
+				return
+			}
+
+			response, ok := d.(pkg.ChatResponse)
+			if !ok {
+				return // "[DONE]"
+			}
+
+			for k, v := range response.Usage {
+				usage[k] += v
+			}
+
+			if response.Model != "" {
+				model = response.Model
+			}
+
+			if !sse {
+				data = response
+				return
+			}
+
+			if len(response.Choices) == 0 || response.Choices[0].Delta == nil {
+				if len(response.Choices) > 0 && response.Choices[0].FinishReason != nil {
+					finish = *response.Choices[0].FinishReason
+				}
+				return
+			}
+
+			choice := response.Choices[0]
+			cited = append(cited, choice.Delta.Annotations...)
+			// 工具调用原样输出
+			if len(choice.Delta.ToolCalls) > 0 {
+				passthrough = true
+				setSSEHeader(ctx)
+				event(ctx, d)
+				return
+			}
+
+			if choice.FinishReason != nil {
+				finish = *choice.FinishReason
+			}
+
+			if flushed {
+				emit(choice.Delta.Content, choice.Delta.ReasoningContent)
+			} else {
+				head.WriteString(choice.Delta.Content)
+				reasoning.WriteString(choice.Delta.ReasoningContent)
+				if head.Len() >= continueHeadSize {
+					flushed = true
+					str := trimOverlap(content.String(), head.String())
+					offset -= utf8.RuneCountInString(head.String()) - utf8.RuneCountInString(str)
+					emit(str, reasoning.String())
+					reasoning.Reset()
+				}
+			}
+
+			if ctx.GetBool(vars.GinClose) {
+				child.Set(vars.GinClose, true)
+			}
+		})
+
+		// 续写的内容经 emit 重新整形
+		skipShaper(child)
+		complete(child, value)
+		cancel()
+		ctx.Set(vars.GinCompletionUsage, usage)
+
+		if code != http.StatusOK {
+			if NotSSEHeader(ctx) {
+				writeJSON(ctx, code, data)
+				return
+			}
+			// 已开始向客户端输出，只能记录并结束
+			common.Logger(ctx).Errorf("continue round[%d] error: %v", round, data)
+			AuditResponse(ctx, code, data)
+			break
+		}
+
+		if passthrough {
+			return
+		}
+
+		if completion.Stream {
+			if !flushed {
+				str := trimOverlap(content.String(), head.String())
+				offset -= utf8.RuneCountInString(head.String()) - utf8.RuneCountInString(str)
+				emit(str, reasoning.String())
+				reasoning.Reset()
+			}
+		} else {
+			response, ok := data.(pkg.ChatResponse)
+			if !ok || len(response.Choices) == 0 || response.Choices[0].Message == nil {
+				if round == 0 {
+					ErrResponse(ctx, -1, "empty response")
+					return
+				}
+				break
+			}
+
+			message := response.Choices[0].Message
+			if len(message.ToolCalls) > 0 {
+				writeJSON(ctx, http.StatusOK, response)
+				return
+			}
+
+			if response.Choices[0].FinishReason != nil {
+				finish = *response.Choices[0].FinishReason
+			}
+
+			str := message.Content
+			if round > 0 {
+				str = trimOverlap(content.String(), str)
+				offset -= utf8.RuneCountInString(message.Content) - utf8.RuneCountInString(str)
+			}
+			content.WriteString(str)
+			reasoning.WriteString(message.ReasoningContent)
+			cited = message.Annotations
+		}
+		annotations = append(annotations, shiftAnnotations(cited, offset)...)
+
+		if round >= rounds || ctx.GetBool(vars.GinClose) || !truncated(ctx, content.String(), finish) {
+			break
+		}
+		common.Logger(ctx).Infof("continue round[%d]: answer is truncated, finish_reason: %s", round+1, finish)
+	}
+
+	// 达到最大轮数仍被截断时结束原因为 length，content_filter 等原样保留
+	switch {
+	case finish != "" && finish != stop && finish != "length":
+		ctx.Set(vars.GinFinishReason, finish)
+	case truncated(ctx, content.String(), finish):
+		ctx.Set(vars.GinFinishReason, "length")
+	}
+
+	if len(annotations) > 0 {
+		ctx.Set(vars.GinAnnotations, annotations)
+	}
+
+	if completion.Stream {
+		SSEResponse(ctx, model, "[DONE]", created)
+		return
+	}
+
+	if reasoning.Len() > 0 {
+		ctx.Set(vars.GinReasoning, bytes.NewBufferString(reasoning.String()))
+	}
+	Response(ctx, model, content.String())
+}
+
+// 判断回复是否被截断
+func truncated(ctx *gin.Context, content, finish string) bool {
+	if finish == "length" {
+		return true
+	}
+
+	if strings.Count(content, "```")%2 == 1 {
+		return true
+	}
+
+	for _, pattern := range pkg.Config.GetStringSlice("continue.patterns") {
+		compile, err := regexp.Compile(pattern)
+		if err != nil {
+			common.Logger(ctx).Warnf("continue pattern '%s' error: %v", pattern, err)
+			continue
+		}
+		if compile.MatchString(content) {
+			return true
+		}
+	}
+	return false
+}
+
+func continueMessage(ctx *gin.Context, content string) string {
+	message, err := templateBuilder().
+		Vars("fence", strings.Count(content, "```")%2 == 1).
+		Do()(agent.Continue)
+	if err != nil {
+		common.Logger(ctx).Error(err)
+		return "continue"
+	}
+	return message
+}
+
+// 去除续写内容中与已输出内容重叠的开头，以及未闭合代码块时重新开始的代码块标记
+func trimOverlap(prev, next string) string {
+	if strings.Count(prev, "```")%2 == 1 {
+		if loc := fenceOpener.FindStringIndex(next); loc != nil {
+			next = next[loc[1]:]
+		}
+	}
+
+	size := len(next)
+	if size > len(prev) {
+		size = len(prev)
+	}
+	if size > 1024 {
+		size = 1024
+	}
+
+	// 过短的重叠可能只是巧合，不做处理
+	for k := size; k >= 8; k-- {
+		if strings.HasSuffix(prev, next[:k]) {
+			return next[k:]
+		}
+	}
+	return next
+}
+
+// 引用位置（start_index、end_index）加上偏移，转换为拼接后内容中的位置
+func shiftAnnotations(annotations []pkg.Keyv[interface{}], offset int) (values []pkg.Keyv[interface{}]) {
+	shift := func(value interface{}) interface{} {
+		switch i := value.(type) {
+		case int:
+			return max(i+offset, 0)
+		case float64:
+			return max(int(i)+offset, 0)
+		default:
+			return value
+		}
+	}
+
+	for _, annotation := range annotations {
+		value := make(pkg.Keyv[interface{}], len(annotation))
+		for k, v := range annotation {
+			if m, ok := v.(map[string]interface{}); ok {
+				shifted := make(map[string]interface{}, len(m))
+				for mk, mv := range m {
+					if mk == "start_index" || mk == "end_index" {
+						mv = shift(mv)
+					}
+					shifted[mk] = mv
+				}
+				v = shifted
+			}
+			value[k] = v
+		}
+		values = append(values, value)
+	}
+	return
+}
@@ -0,0 +1,131 @@
+package middle
+
+import (
+	"encoding/json"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"testing"
+	"time"
+)
+
+func TestTrimOverlap(t *testing.T) {
+	for _, c := range []struct {
+		name, prev, next, expected string
+	}{
+		{"overlap", "hello world, this is", "world, this is a test", " a test"},
+		{"no overlap", "hello world", "a new line", "a new line"},
+		{"short overlap", "the end", "end of it", "end of it"}, // 少于 8 个字符视为巧合
+		{"whole next", "0123456789", "3456789", "3456789"},
+		{"reopened fence", "```go\nfunc main() {", "```go\n}\n```", "}\n```"},
+		{"closed fence", "```go\n```\n", "```go\nx\n```", "```go\nx\n```"},
+		{"fence and overlap", "```go\nfunc main() {\n\tprintln(1)", "```go\n\tprintln(1)\n}\n```", "\n}\n```"},
+	} {
+		if result := trimOverlap(c.prev, c.next); result != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, result)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	testutil.Config(t).Set("continue.patterns", []string{`[,，]$`, `(`})
+	ctx, _ := testutil.Completion(pkg.ChatCompletion{})
+	for _, c := range []struct {
+		name, content, finish string
+		expected              bool
+	}{
+		{"stop", "done.", "stop", false},
+		{"length", "done.", "length", true},
+		{"unclosed fence", "```go\nfunc main() {", "stop", true},
+		{"closed fence", "```go\n```", "stop", false},
+		{"pattern", "first,", "stop", true},
+		{"pattern miss", "first.", "", false},
+	} {
+		if result := truncated(ctx, c.content, c.finish); result != c.expected {
+			t.Errorf("%s: expected %v", c.name, c.expected)
+		}
+	}
+}
+
+// 每轮回复一段内容，引用位置相对于本轮内容；finishes 为各轮的结束原因
+func continueRounds(finishes ...string) func(ctx *gin.Context, completion pkg.ChatCompletion) {
+	replies := []string{"part one,", "part two."}
+	return func(ctx *gin.Context, completion pkg.ChatCompletion) {
+		round := (len(completion.Messages) - 1) / 2
+		ctx.Set(vars.GinFinishReason, finishes[round])
+		ctx.Set(vars.GinCompletionUsage, map[string]int{"prompt_tokens": 1, "completion_tokens": 2, "total_tokens": 3})
+		ctx.Set(vars.GinAnnotations, []pkg.Keyv[interface{}]{{
+			"type":         "url_citation",
+			"url_citation": map[string]interface{}{"start_index": 0, "end_index": 4, "url": replies[round]},
+		}})
+
+		if !completion.Stream {
+			Response(ctx, "mock", replies[round])
+			return
+		}
+		created := time.Now().Unix()
+		SSEResponse(ctx, "mock", replies[round], created)
+		SSEResponse(ctx, "mock", "[DONE]", created)
+	}
+}
+
+func TestCompleteContinue(t *testing.T) {
+	testutil.Config(t).Set("continue.rounds", 1)
+
+	type result struct {
+		content, finish string
+		usage           map[string]int
+		annotations     []pkg.Keyv[interface{}]
+	}
+
+	complete := func(stream bool, finishes ...string) (r result) {
+		completion := pkg.ChatCompletion{Stream: stream, Messages: []pkg.Keyv[interface{}]{{"role": "user", "content": "hi"}}}
+		ctx, recorder := testutil.Completion(completion)
+		CompleteContinue(ctx, completion, continueRounds(finishes...))
+
+		if !stream {
+			var response pkg.ChatResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			message := response.Choices[0].Message
+			return result{message.Content, *response.Choices[0].FinishReason, response.Usage, message.Annotations}
+		}
+
+		responses, _ := sseEvents(t, recorder.Body.String())
+		for _, response := range responses {
+			choice := response.Choices[0]
+			if choice.Delta != nil {
+				r.content += choice.Delta.Content
+				r.annotations = append(r.annotations, choice.Delta.Annotations...)
+			}
+			if choice.FinishReason != nil {
+				r.finish = *choice.FinishReason
+				r.usage = response.Usage
+			}
+		}
+		return
+	}
+
+	for _, stream := range []bool{false, true} {
+		r := complete(stream, "length", "stop")
+		if r.content != "part one,part two." || r.finish != "stop" || r.usage["total_tokens"] != 6 {
+			t.Fatalf("unexpected result[stream=%v]: %+v", stream, r)
+		}
+
+		// 第二轮的引用偏移到拼接后的位置
+		if len(r.annotations) != 2 {
+			t.Fatalf("unexpected annotations[stream=%v]: %v", stream, r.annotations)
+		}
+		citation := r.annotations[1].GetKeyv("url_citation")
+		if citation["url"] != "part two." || citation["start_index"] != float64(9) || citation["end_index"] != float64(13) {
+			t.Fatalf("unexpected annotation[stream=%v]: %v", stream, citation)
+		}
+
+		// 最后一轮仍被截断
+		if r = complete(stream, "length", "length"); r.finish != "length" {
+			t.Fatalf("expected length[stream=%v]: %+v", stream, r)
+		}
+	}
+}
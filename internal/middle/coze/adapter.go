@@ -1,13 +1,15 @@
 package coze
 
 import (
+	"fmt"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/coze-api"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 )
@@ -16,22 +18,31 @@ var (
 	Adapter = API{}
 	Model   = "coze"
 
-	// 35-16k
-	botId35_16k   = "7353052833752694791"
-	version35_16k = "1712016747307"
-	scene35_16k   = 2
-
-	// 8k
-	botId8k   = "7353047124357365778"
-	version8k = "1712645567468"
-	scene8k   = 2
-
-	// 128k
-	botId128k   = "7353048532129644562"
-	version128k = "1712016880672"
-	scene128k   = 2
+	// 未配置 coze.bots 时使用的内置 bot
+	defaultBots = []bot{
+		// 8k
+		{Id: "7353047124357365778", Version: "1712645567468", Scene: 2, Context: 8000},
+		// 128k
+		{Id: "7353048532129644562", Version: "1712016880672", Scene: 2, Context: 128000},
+		// 35-16k，只绘画用3.5 16k即可
+		{Id: "7353052833752694791", Version: "1712016747307", Scene: 2, Images: true},
+	}
 )
 
+// coze bot 配置
+//
+//	model: 对外的模型名，如 coze/my-bot，为空时不单独暴露
+//	context: 上下文大小（token），大于 0 时参与 coze 模型按长度的自动选择
+//	images: 是否用于绘画
+type bot struct {
+	Model   string `mapstructure:"model"`
+	Id      string `mapstructure:"id"`
+	Version string `mapstructure:"version"`
+	Scene   int    `mapstructure:"scene"`
+	Context int    `mapstructure:"context"`
+	Images  bool   `mapstructure:"images"`
+}
+
 type API struct {
 	middle.BaseAdapter
 }
@@ -41,6 +52,12 @@ func (API) Match(ctx *gin.Context, model string) bool {
 		return true
 	}
 
+	for _, b := range bots(ctx) {
+		if b.Model != "" && b.Model == model {
+			return true
+		}
+	}
+
 	token := ctx.GetString("token")
 	if model == "dall-e-3" {
 		if strings.Contains(token, "msToken=") || strings.Contains(token, "sessionid=") {
@@ -50,8 +67,12 @@ func (API) Match(ctx *gin.Context, model string) bool {
 	return false
 }
 
+func (API) Sampling(*gin.Context, string) []string {
+	return []string{}
+}
+
 func (API) Models() []middle.Model {
-	return []middle.Model{
+	models := []middle.Model{
 		{
 			Id:      Model,
 			Object:  "model",
@@ -59,6 +80,19 @@ func (API) Models() []middle.Model {
 			By:      Model + "-adapter",
 		},
 	}
+
+	for _, b := range bots(nil) {
+		if b.Model == "" {
+			continue
+		}
+		models = append(models, middle.Model{
+			Id:      b.Model,
+			Object:  "model",
+			Created: 1686935002,
+			By:      Model + "-adapter",
+		})
+	}
+	return models
 }
 
 func (API) Completion(ctx *gin.Context) {
@@ -78,7 +112,7 @@ func (API) Completion(ctx *gin.Context) {
 
 	pMessages, tokens := mergeMessages(completion.Messages)
 	ctx.Set("tokens", tokens)
-	options := newOptions(proxies, pMessages)
+	options := newOptions(ctx, proxies, completion.Model, pMessages)
 	co, msToken := extCookie(cookie)
 	chat := coze.New(co, msToken, options)
 
@@ -110,8 +144,15 @@ func (API) Generation(ctx *gin.Context) {
 		generation = common.GetGinGeneration(ctx)
 	)
 
-	// 只绘画用3.5 16k即可
-	options := coze.NewDefaultOptions(botId35_16k, version35_16k, scene35_16k, proxies)
+	b := defaultBots[2]
+	for _, value := range bots(ctx) {
+		if value.Images {
+			b = value
+			break
+		}
+	}
+
+	options := coze.NewDefaultOptions(b.Id, b.Version, b.Scene, proxies)
 	co, msToken := extCookie(cookie)
 	chat := coze.New(co, msToken, options)
 	image, err := chat.Images(ctx.Request.Context(), generation.Prompt)
@@ -123,7 +164,7 @@ func (API) Generation(ctx *gin.Context) {
 	if (generation.Size == "HD" || strings.HasPrefix(generation.Size, "1792x")) && common.HasMfy() {
 		v, e := common.Magnify(ctx, image)
 		if e != nil {
-			logrus.Error(e)
+			common.Logger(ctx).Error(e)
 		} else {
 			image = v
 		}
@@ -138,16 +179,50 @@ func (API) Generation(ctx *gin.Context) {
 	})
 }
 
-func newOptions(proxies string, pMessages []coze.Message) (options coze.Options) {
-	opts8k := coze.NewDefaultOptions(botId8k, version8k, scene8k, proxies)
-	opts128k := coze.NewDefaultOptions(botId128k, version128k, scene128k, proxies)
+// 指定了 bot 模型名时使用该 bot，否则按上下文长度从小到大选择可容纳的 bot（预留 1/8 给回复）
+func newOptions(ctx *gin.Context, proxies, model string, pMessages []coze.Message) (options coze.Options) {
+	var candidates []bot
+	for _, b := range bots(ctx) {
+		if b.Model != "" && b.Model == model {
+			return coze.NewDefaultOptions(b.Id, b.Version, b.Scene, proxies)
+		}
+		if b.Context > 0 {
+			candidates = append(candidates, b)
+		}
+	}
+
+	if len(candidates) == 0 {
+		candidates = append(candidates, defaultBots[:2]...)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Context < candidates[j].Context
+	})
 
-	options = opts8k
 	tokensL := calcTokens(pMessages)
-	if tokensL > 7000 { // 大于7k token 使用 gpt-128k
-		options = opts128k
+	b := candidates[len(candidates)-1]
+	for _, candidate := range candidates {
+		if tokensL <= candidate.Context*7/8 {
+			b = candidate
+			break
+		}
+	}
+	return coze.NewDefaultOptions(b.Id, b.Version, b.Scene, proxies)
+}
+
+// 配置中的 bot 列表，解析结果随配置缓存，未配置时使用内置的 bot
+var configBots = common.NewConfigValue(func(config *viper.Viper) (values []bot, err error) {
+	if err = config.UnmarshalKey("coze.bots", &values); err != nil {
+		err = fmt.Errorf("coze: %v", err)
 	}
 	return
+})
+
+func bots(ctx *gin.Context) []bot {
+	if values := configBots.Get(ctx); len(values) > 0 {
+		return values
+	}
+	return defaultBots
 }
 
 func extCookie(co string) (cookie, msToken string) {
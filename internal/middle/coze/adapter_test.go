@@ -0,0 +1,46 @@
+package coze
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/coze-api"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewOptions(t *testing.T) {
+	testutil.Config(t)
+	ctx, _ := testutil.Context(http.MethodPost, "/v1/chat/completions")
+	short := []coze.Message{{Role: "user", Content: "hi"}}
+	long := []coze.Message{{Role: "user", Content: strings.Repeat("hello world ", 1000)}}
+
+	// 内置 bot 按长度选择
+	if options := newOptions(ctx, "", Model, short); !reflect.DeepEqual(options, coze.NewDefaultOptions(defaultBots[0].Id, defaultBots[0].Version, defaultBots[0].Scene, "")) {
+		t.Fatalf("unexpected default options: %v", options)
+	}
+
+	// 配置随实例缓存，替换为新的配置后重新解析
+	testutil.Config(t).Set("coze.bots", []interface{}{
+		map[string]interface{}{"id": "large", "version": "1", "scene": 2, "context": 32000},
+		map[string]interface{}{"id": "small", "version": "1", "scene": 2, "context": 1000},
+		map[string]interface{}{"id": "mine", "version": "2", "scene": 1, "model": "coze/my-bot"},
+	})
+
+	if !Adapter.Match(ctx, "coze/my-bot") || Adapter.Match(ctx, "coze/other") || len(Adapter.Models()) != 2 {
+		t.Fatal("unexpected models")
+	}
+
+	for model, expected := range map[string]coze.Options{
+		"coze/my-bot": coze.NewDefaultOptions("mine", "2", 1, ""),
+		Model:         coze.NewDefaultOptions("small", "1", 2, ""),
+	} {
+		if options := newOptions(ctx, "", model, short); !reflect.DeepEqual(options, expected) {
+			t.Fatalf("unexpected options for %s: %v", model, options)
+		}
+	}
+
+	if options := newOptions(ctx, "", Model, long); !reflect.DeepEqual(options, coze.NewDefaultOptions("large", "1", 2, "")) {
+		t.Fatalf("unexpected options for long messages: %v", options)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/coze-api"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"strings"
 	"time"
 )
@@ -49,14 +48,15 @@ func waitMessage(chatResponse chan string, cancel func(str string) bool) (conten
 func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, cancel chan error, chatResponse chan string, sse bool) {
 	content := ""
 	created := time.Now().Unix()
-	logrus.Infof("waitResponse ...")
+	common.Logger(ctx).Infof("waitResponse ...")
 	tokens := ctx.GetInt("tokens")
+	model := common.GetGinCompletion(ctx).Model
 
 	for {
 		select {
 		case err := <-cancel:
 			if err != nil {
-				logrus.Error(err)
+				common.Logger(ctx).Error(err)
 				if middle.NotSSEHeader(ctx) {
 					middle.ErrResponse(ctx, -1, err)
 				}
@@ -64,14 +64,19 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, cancel chan error, c
 			}
 			goto label
 		default:
-			raw, ok := <-chatResponse
+			raw, ok := middle.Receive(ctx, chatResponse)
+			if middle.IsClosed(ctx) {
+				middle.Abandon(ctx, chatResponse)
+				return
+			}
+
 			if !ok {
 				goto label
 			}
 
 			if strings.HasPrefix(raw, "error: ") {
 				err := strings.TrimPrefix(raw, "error: ")
-				logrus.Error(err)
+				common.Logger(ctx).Error(err)
 				if middle.NotSSEHeader(ctx) {
 					middle.ErrResponse(ctx, -1, err)
 				}
@@ -84,10 +89,10 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, cancel chan error, c
 				continue
 			}
 
-			fmt.Printf("----- raw -----\n %s\n", raw)
+			common.LogRaw(ctx, raw)
 			raw = pkg.ExecMatchers(matchers, raw)
 			if sse {
-				middle.SSEResponse(ctx, Model, raw, created)
+				middle.SSEResponse(ctx, model, raw, created)
 			}
 			content += raw
 		}
@@ -96,9 +101,9 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, cancel chan error, c
 label:
 	ctx.Set(vars.GinCompletionUsage, common.CalcUsageTokens(content, tokens))
 	if !sse {
-		middle.Response(ctx, Model, content)
+		middle.Response(ctx, model, content)
 	} else {
-		middle.SSEResponse(ctx, Model, "[DONE]", created)
+		middle.SSEResponse(ctx, model, "[DONE]", created)
 	}
 }
 
@@ -1,17 +1,17 @@
 package coze
 
 import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/coze-api"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"net/http"
 	"strings"
 )
 
 func completeToolCalls(ctx *gin.Context, cookie, proxies string, completion pkg.ChatCompletion) bool {
-	logrus.Infof("completeTools ...")
+	common.Logger(ctx).Infof("completeTools ...")
 	exec, err := middle.CompleteToolCalls(ctx, completion, func(message string) (string, error) {
 		var notebook = ctx.GetBool("notebook")
 		pMessages := []coze.Message{
@@ -22,7 +22,7 @@ func completeToolCalls(ctx *gin.Context, cookie, proxies string, completion pkg.
 		}
 
 		co, msToken := extCookie(cookie)
-		options := newOptions(proxies, pMessages)
+		options := newOptions(ctx, proxies, completion.Model, pMessages)
 		chat := coze.New(co, msToken, options)
 
 		query := ""
@@ -0,0 +1,170 @@
+package middle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/agent"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 是否需要结构化输出：response_format.type 为 json_object、json_schema
+func NeedToFormat(completion pkg.ChatCompletion) bool {
+	switch completion.ResponseFormat.GetString("type") {
+	case "json_object", "json_schema":
+		return true
+	default:
+		return false
+	}
+}
+
+// 结构化输出：在上下文中追加JSON输出要求，提取并校验AI的回复，
+// 校验失败时携带错误信息重试，流式请求只在校验通过后才输出内容。
+//
+//	native: 上游原生支持 response_format，不追加输出要求，只做校验
+//	complete: 单次补全的执行函数
+func CompleteFormat(ctx *gin.Context, completion pkg.ChatCompletion, native bool, complete func(ctx *gin.Context, completion pkg.ChatCompletion)) {
+	var (
+		retry   = 2
+		created = time.Now().Unix()
+		usage   = make(map[string]int)
+		schema  = completion.ResponseFormat.GetKeyv("json_schema").GetKeyv("schema")
+	)
+
+	if pkg.Config.IsSet("response_format.retry") {
+		retry = pkg.Config.GetInt("response_format.retry")
+	}
+
+	base := completion
+	base.Messages = append([]pkg.Keyv[interface{}](nil), completion.Messages...)
+	if !native {
+		schemaStr := ""
+		if schema != nil {
+			indent, _ := json.MarshalIndent(schema, "", "  ")
+			schemaStr = string(indent)
+		}
+
+		instruction, err := templateBuilder().
+			Vars("schema", schemaStr).
+			Do()(agent.JSONFormat)
+		if err != nil {
+			ErrResponse(ctx, -1, err)
+			return
+		}
+
+		base.Messages = append(base.Messages, pkg.Keyv[interface{}]{
+			"role": "user", "content": instruction,
+		})
+	}
+
+	for count := 0; ; count++ {
+		var (
+			code = http.StatusOK
+			data interface{}
+		)
+
+		child, cancel := Fork(ctx, func(c int, d interface{}, sse bool) {
+			if !sse {
+				code, data = c, d
+			}
+		})
+
+		value := copyCompletion(ctx, base)
+		value.Stream = false
+		complete(child, value)
+		cancel()
+
+		response, ok := data.(pkg.ChatResponse)
+		if code != http.StatusOK || !ok || len(response.Choices) == 0 || response.Choices[0].Message == nil {
+			if data == nil {
+				data = gin.H{"error": map[string]string{"message": "empty response"}}
+			}
+			writeJSON(ctx, code, data)
+			return
+		}
+
+		for k, v := range response.Usage {
+			usage[k] += v
+		}
+		ctx.Set(vars.GinCompletionUsage, usage)
+
+		message := response.Choices[0].Message
+		// 工具调用不做格式处理，原样透传
+		if len(message.ToolCalls) > 0 {
+			if message.ReasoningContent != "" {
+				ctx.Set(vars.GinReasoning, bytes.NewBufferString(message.ReasoningContent))
+			}
+			if completion.Stream {
+				SSEResponse(ctx, response.Model, message.Content, created)
+				SSEToolCallsResponse(ctx, response.Model, indexToolCalls(message.ToolCalls), created)
+				SSEToolCallsResponse(ctx, response.Model, nil, created)
+			} else {
+				ToolCallsResponse(ctx, response.Model, message.Content, message.ToolCalls)
+			}
+			return
+		}
+
+		str, obj, e := common.ExtractJSON(message.Content)
+		var errs []string
+		if e != nil {
+			errs = append(errs, e.Error())
+		} else if _, o := obj.(map[string]interface{}); !o && schema == nil {
+			errs = append(errs, "output must be a JSON object")
+		} else if schema != nil {
+			errs = common.ValidateSchema(schema, obj)
+		}
+
+		if len(errs) == 0 {
+			if message.ReasoningContent != "" {
+				ctx.Set(vars.GinReasoning, bytes.NewBufferString(message.ReasoningContent))
+			}
+			if completion.Stream {
+				SSEResponse(ctx, response.Model, str, created)
+				SSEResponse(ctx, response.Model, "[DONE]", created)
+			} else {
+				Response(ctx, response.Model, str)
+			}
+			return
+		}
+
+		common.Logger(ctx).Warnf("response_format validation failed[%d]: %s", count, strings.Join(errs, "; "))
+		if count >= retry {
+			ErrResponse(ctx, -1, fmt.Sprintf("response_format validation failed: %s", strings.Join(errs, "; ")))
+			return
+		}
+
+		retryMessage, err := templateBuilder().
+			Vars("errors", errs).
+			Do()(agent.JSONFormatRetry)
+		if err != nil {
+			ErrResponse(ctx, -1, err)
+			return
+		}
+
+		base.Messages = append(base.Messages,
+			pkg.Keyv[interface{}]{"role": "assistant", "content": message.Content},
+			pkg.Keyv[interface{}]{"role": "user", "content": retryMessage})
+	}
+}
+
+// 流式输出的 tool_calls 增量需要 index
+func indexToolCalls(calls []pkg.Keyv[interface{}]) []pkg.Keyv[interface{}] {
+	values := make([]pkg.Keyv[interface{}], len(calls))
+	for index, call := range calls {
+		value := make(pkg.Keyv[interface{}], len(call)+1)
+		for k, v := range call {
+			value[k] = v
+		}
+		if !value.Has("index") {
+			value["index"] = index
+		}
+		values[index] = value
+	}
+	return values
+}
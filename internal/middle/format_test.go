@@ -0,0 +1,96 @@
+package middle
+
+import (
+	"encoding/json"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"strings"
+	"testing"
+)
+
+func TestCompleteFormatToolCalls(t *testing.T) {
+	testutil.Config(t)
+
+	// 原生适配器返回的 tool_calls 为 map[string]interface{}
+	complete := func(ctx *gin.Context, completion pkg.ChatCompletion) {
+		ToolCallsResponse(ctx, "mock", "", []pkg.Keyv[interface{}]{
+			{"id": "call_1", "type": "function", "function": map[string]interface{}{"name": "weather", "arguments": `{"city":"Paris"}`}},
+			{"id": "call_2", "type": "function", "function": map[string]interface{}{"name": "weather", "arguments": `{"city":"Rome"}`}},
+		})
+	}
+
+	for _, stream := range []bool{false, true} {
+		completion := pkg.ChatCompletion{
+			Stream:         stream,
+			ResponseFormat: pkg.Keyv[interface{}]{"type": "json_object"},
+			Messages:       []pkg.Keyv[interface{}]{{"role": "user", "content": "weather?"}},
+		}
+		ctx, recorder := testutil.Completion(completion)
+		CompleteFormat(ctx, completion, false, complete)
+
+		body := recorder.Body.String()
+		var calls []pkg.Keyv[interface{}]
+		var reason string
+		if stream {
+			responses, _ := sseEvents(t, body)
+			for _, response := range responses {
+				if delta := response.Choices[0].Delta; delta != nil {
+					calls = append(calls, delta.ToolCalls...)
+				}
+				if finish := response.Choices[0].FinishReason; finish != nil {
+					reason = *finish
+				}
+			}
+		} else {
+			var response pkg.ChatResponse
+			if err := json.Unmarshal([]byte(body), &response); err != nil {
+				t.Fatal(err)
+			}
+			calls = response.Choices[0].Message.ToolCalls
+			reason = *response.Choices[0].FinishReason
+		}
+
+		if len(calls) != 2 || reason != "tool_calls" {
+			t.Fatalf("unexpected response[stream=%v]: %s", stream, body)
+		}
+		for index, call := range calls {
+			fn := call.GetKeyv("function")
+			if fn.GetString("name") != "weather" || !strings.Contains(fn.GetString("arguments"), "city") {
+				t.Fatalf("unexpected call[%d]: %v", index, call)
+			}
+			if stream && call["index"] != float64(index) {
+				t.Fatalf("unexpected index[%d]: %v", index, call)
+			}
+		}
+	}
+}
+
+func TestCompleteFormatNative(t *testing.T) {
+	testutil.Config(t)
+
+	var sizes []int
+	complete := func(ctx *gin.Context, completion pkg.ChatCompletion) {
+		sizes = append(sizes, len(completion.Messages))
+		if len(sizes) == 1 {
+			Response(ctx, "mock", "not json")
+			return
+		}
+		Response(ctx, "mock", `{"ok":true}`)
+	}
+
+	completion := pkg.ChatCompletion{
+		ResponseFormat: pkg.Keyv[interface{}]{"type": "json_object"},
+		Messages:       []pkg.Keyv[interface{}]{{"role": "user", "content": "hi"}},
+	}
+	ctx, recorder := testutil.Completion(completion)
+	CompleteFormat(ctx, completion, true, complete)
+
+	// 原生支持时不追加输出要求，校验失败仍会重试
+	if len(sizes) != 2 || sizes[0] != 1 || sizes[1] != 3 {
+		t.Fatalf("unexpected messages: %v", sizes)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, `"content":"{\"ok\":true}"`) {
+		t.Fatalf("unexpected response: %s", body)
+	}
+}
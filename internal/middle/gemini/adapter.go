@@ -9,7 +9,6 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/emit.io"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"net/http"
 	"reflect"
 	"strings"
@@ -36,7 +35,10 @@ type cookieOpts struct {
 }
 
 type candidatesResponse struct {
-	Candidates []candidate `json:"candidates"`
+	Candidates     []candidate `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
 }
 
 type candidate struct {
@@ -61,6 +63,14 @@ func (API) Match(_ *gin.Context, model string) bool {
 	}
 }
 
+func (API) Sampling(ctx *gin.Context, _ string) []string {
+	if strings.HasPrefix(ctx.GetString("token"), "AIzaSy") {
+		return []string{"temperature", "top_p", "top_k", "max_tokens", "presence_penalty", "frequency_penalty", "seed"}
+	}
+	// goole15 只支持以下参数，max_tokens、penalties、seed 无法传递
+	return []string{"temperature", "top_p", "top_k"}
+}
+
 func (API) Models() []middle.Model {
 	return []middle.Model{
 		{
@@ -100,9 +110,16 @@ func complete(ctx *gin.Context) {
 		matchers   = com.GetGinMatchers(ctx)
 	)
 
-	newMessages, tokens := mergeMessages(completion.Messages)
+	if !middle.SamplingLimit(ctx, "stop", len(completion.StopSequences), maxStopSequences) {
+		return
+	}
+
+	// gemini-1.0 不支持 systemInstruction
+	instruction := !strings.HasPrefix(completion.Model, "gemini-1.0")
+	system, newMessages, tokens := mergeMessages(completion.Messages, instruction)
 	ctx.Set("tokens", tokens)
-	response, err := build(ctx.Request.Context(), proxies, cookie, newMessages, completion)
+	payload := newPayload(ctx, system, newMessages, completion)
+	response, err := build(ctx, proxies, cookie, completion.Model, payload)
 	if err != nil {
 		middle.ErrResponse(ctx, -1, err)
 		return
@@ -167,7 +184,6 @@ func extCookie15(ctx context.Context, token, proxies string) (sign, auth, key, u
 		//
 	} else if co, ok := gkv[h]; ok {
 		opts = co
-		logrus.Info("cookie: ", co.cookie)
 	} else {
 		s := strings.Split(token, "|")
 		if len(s) < 4 {
@@ -223,7 +239,6 @@ func extCookie15(ctx context.Context, token, proxies string) (sign, auth, key, u
 	}
 
 	cookie = opts.cookie
-	logrus.Info("cookie: ", cookie)
 	index := strings.Index(cookie, "[sign=")
 	if index > -1 {
 		end := strings.Index(cookie[index:], "]")
@@ -0,0 +1,141 @@
+package gemini
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewPayload(t *testing.T) {
+	testutil.Config(t).Set("gemini.safety", map[string]interface{}{"all": "BLOCK_ONLY_HIGH", "harassment": "BLOCK_LOW_AND_ABOVE"})
+
+	completion := pkg.ChatCompletion{
+		Model:          "gemini-1.5-flash-latest",
+		StopSequences:  []string{"a", "b", "c", "d", "e", "f"},
+		ResponseFormat: pkg.Keyv[interface{}]{"type": "json_object"},
+		Messages: []pkg.Keyv[interface{}]{
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": "hi"},
+		},
+	}
+
+	system, messages, _ := mergeMessages(completion.Messages, true)
+	if system != "be brief" || len(messages) != 1 || messages[0]["role"] != "user" {
+		t.Fatalf("unexpected messages: %s %v", system, messages)
+	}
+
+	if _, messages, _ = mergeMessages(completion.Messages, false); len(messages) != 3 {
+		t.Fatalf("unexpected legacy messages: %v", messages)
+	}
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Set("safety", pkg.Keyv[interface{}]{"dangerous_content": "block_none"})
+	payload := newPayload(ctx, system, messages, completion)
+
+	config := payload["generationConfig"].(map[string]any)
+	if len(config["stopSequences"].([]string)) != 5 || config["responseMimeType"] != "application/json" {
+		t.Fatalf("unexpected generationConfig: %v", config)
+	}
+
+	thresholds := make(map[string]string)
+	for _, setting := range payload["safetySettings"].([]map[string]string) {
+		thresholds[setting["category"]] = setting["threshold"]
+	}
+	if thresholds["HARM_CATEGORY_HARASSMENT"] != "BLOCK_LOW_AND_ABOVE" ||
+		thresholds["HARM_CATEGORY_HATE_SPEECH"] != "BLOCK_ONLY_HIGH" ||
+		thresholds["HARM_CATEGORY_DANGEROUS_CONTENT"] != "BLOCK_NONE" {
+		t.Fatalf("unexpected safetySettings: %v", thresholds)
+	}
+
+	if _, ok := payload["systemInstruction"]; !ok {
+		t.Fatal("missing systemInstruction")
+	}
+}
+
+func TestWaitResponseSafety(t *testing.T) {
+	testutil.Config(t)
+	ctx, recorder := testutil.Completion(pkg.ChatCompletion{Model: "gemini-1.5-flash-latest"})
+
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Once"}]},"index":0}]}` + "\n\n" +
+			`data: {"candidates":[{"finishReason":"SAFETY","index":0,"safetyRatings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"HIGH"}]}]}` + "\n\n")),
+	}
+	waitResponse(ctx, nil, response, false)
+
+	body := recorder.Body.String()
+	if !strings.Contains(body, `"content":"Once"`) || !strings.Contains(body, `"finish_reason":"content_filter"`) {
+		t.Fatalf("unexpected response: %s", body)
+	}
+}
+
+func TestMergeToolMessages(t *testing.T) {
+	_, messages, _ := mergeMessages([]pkg.Keyv[interface{}]{
+		{"role": "user", "content": "weather in Paris and Rome?"},
+		{"role": "assistant", "content": "Checking.", "tool_calls": []interface{}{
+			map[string]interface{}{"id": "call_1", "type": "function", "function": map[string]interface{}{"name": "get-weather", "arguments": `{"city":"Paris"}`}},
+			map[string]interface{}{"id": "call_2", "type": "function", "function": map[string]interface{}{"name": "get-weather", "arguments": `{"city":"Rome"}`}},
+		}},
+		{"role": "tool", "tool_call_id": "call_1", "content": `{"temp":20}`},
+		{"role": "tool", "tool_call_id": "call_2", "content": "sunny"},
+	}, true)
+
+	if len(messages) != 3 {
+		t.Fatalf("unexpected messages: %v", messages)
+	}
+
+	if parts := messages[1]["parts"].([]interface{}); messages[1]["role"] != "model" || len(parts) != 3 {
+		t.Fatalf("unexpected model parts: %v", messages[1])
+	}
+
+	parts := messages[2]["parts"].([]interface{})
+	if len(parts) != 2 {
+		t.Fatalf("unexpected function responses: %v", messages[2])
+	}
+	response := parts[1].(map[string]interface{})["functionResponse"].(map[string]interface{})
+	if response["name"] != "get_weather" || response["response"].(map[string]interface{})["content"] != "sunny" {
+		t.Fatalf("unexpected function response: %v", response)
+	}
+}
+
+func TestWaitResponseToolCalls(t *testing.T) {
+	testutil.Config(t)
+	ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+		Model: "gemini-1.5-flash-latest",
+		Tools: []pkg.Keyv[interface{}]{{"type": "function", "function": map[string]interface{}{"name": "get-weather"}}},
+	})
+
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Checking."},{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"index":0}]}` + "\n\n" +
+			`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Rome"}}}]},"finishReason":"STOP","index":0}]}` + "\n\n")),
+	}
+	waitResponse(ctx, nil, response, true)
+
+	body := recorder.Body.String()
+	for _, expected := range []string{`"content":"Checking."`, `"index":1`, `"name":"get-weather"`, `"arguments":"{\"city\":\"Rome\"}"`, `"finish_reason":"tool_calls"`, "[DONE]"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("missing %s: %s", expected, body)
+		}
+	}
+}
+
+func TestStopSequencesLimit(t *testing.T) {
+	testutil.Config(t).Set("sampling.policy", "reject")
+	ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+		Model:         "gemini-1.5-flash-latest",
+		StopSequences: []string{"a", "b", "c", "d", "e", "f"},
+		Messages:      []pkg.Keyv[interface{}]{{"role": "user", "content": "hi"}},
+	})
+	ctx.Set("token", "AIzaSy-test")
+	Adapter.Completion(ctx)
+
+	if body := recorder.Body.String(); recorder.Code != http.StatusBadRequest || !strings.Contains(body, "at most 5 stop") {
+		t.Fatalf("unexpected response: %d %s", recorder.Code, body)
+	}
+}
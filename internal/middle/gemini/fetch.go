@@ -1,19 +1,23 @@
 package gemini
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	com "github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/emit.io"
-	"github.com/sirupsen/logrus"
+	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
 )
 
+// gemini 最多支持 5 个停止词
+const maxStopSequences = 5
+
 const GOOGLE_BASE_FORMAT = "https://generativelanguage.googleapis.com/v1beta/models/%s:streamGenerateContent?alt=sse&key=%s"
 
 type funcDecl struct {
@@ -26,10 +30,58 @@ type funcDecl struct {
 	} `json:"parameters"`
 }
 
+// 安全分类，配置与标记中使用小写的简称
+var harmCategories = []string{
+	"harassment",
+	"hate_speech",
+	"sexually_explicit",
+	"dangerous_content",
+}
+
 // 构建请求，返回响应
-func build(ctx context.Context, proxies, token string, messages []map[string]interface{}, completion pkg.ChatCompletion) (*http.Response, error) {
-	gURL := fmt.Sprintf(GOOGLE_BASE_FORMAT, completion.Model, token)
+func build(ctx *gin.Context, proxies, token, model string, payload map[string]any) (*http.Response, error) {
+	gURL := fmt.Sprintf(GOOGLE_BASE_FORMAT, model, token)
+
+	marshal, err := json.Marshal(payload)
+	if err != nil {
+		com.Logger(ctx).Error(err)
+		return nil, err
+	}
+
+	res, err := emit.ClientBuilder().
+		Proxies(proxies).
+		Context(ctx.Request.Context()).
+		POST(gURL).
+		JHeader().
+		Bytes(marshal).
+		Do()
+	if err != nil {
+		var e *url.Error
+		if errors.As(err, &e) {
+			e.URL = strings.Replace(e.URL, token, "AIzaSy***", -1)
+		}
+		com.Logger(ctx).Error(err)
+		return nil, err
+	}
 
+	if res.StatusCode != http.StatusOK {
+		h := res.Header
+		if c := h.Get("content-type"); strings.Contains(c, "application/json") {
+			bts, e := io.ReadAll(res.Body)
+			if e == nil {
+				return nil, fmt.Errorf("%s: %s", res.Status, bts)
+			}
+		}
+		return nil, errors.New(res.Status)
+	}
+
+	return res, nil
+}
+
+// 构建请求体，参数基本与openai对齐
+//
+//	system: 不为空时作为 systemInstruction 发送
+func newPayload(ctx *gin.Context, system string, messages []map[string]interface{}, completion pkg.ChatCompletion) map[string]any {
 	if completion.Temperature < 0.1 {
 		completion.Temperature = 1
 	}
@@ -46,18 +98,29 @@ func build(ctx context.Context, proxies, token string, messages []map[string]int
 		completion.TopP = 0.95
 	}
 
-	// 参数基本与openai对齐
 	_funcDecls := make([]funcDecl, 0)
 	if toolsL := len(completion.Tools); toolsL > 0 {
 		for _, v := range completion.Tools {
 			kv := v.GetKeyv("function").GetKeyv("parameters")
-			required, ok := kv.Get("required")
-			if !ok {
-				required = []string{}
+			required := make([]string, 0)
+			switch value := kv["required"].(type) {
+			case []string:
+				required = value
+			case []interface{}:
+				for _, it := range value {
+					if str, ok := it.(string); ok {
+						required = append(required, str)
+					}
+				}
+			}
+
+			paramsType := kv.GetString("type")
+			if paramsType == "" {
+				paramsType = "object"
 			}
 
 			_funcDecls = append(_funcDecls, funcDecl{
-				Name:        strings.Replace(v.GetKeyv("function").GetString("name"), "-", "_", -1),
+				Name:        funcName(v.GetKeyv("function").GetString("name")),
 				Description: v.GetKeyv("function").GetString("description"),
 				Params: struct {
 					Properties map[string]interface{} `json:"properties"`
@@ -65,15 +128,15 @@ func build(ctx context.Context, proxies, token string, messages []map[string]int
 					Type       string                 `json:"type"`
 				}{
 					Properties: kv.GetKeyv("properties"),
-					Required:   required.([]string),
-					Type:       kv.GetString("function"),
+					Required:   required,
+					Type:       paramsType,
 				},
 			})
 		}
 	}
 
 	// fix: Please ensure that multiturn requests ends with a user role or a function response.
-	if messages[0]["role"] != "user" {
+	if len(messages) == 0 || messages[0]["role"] != "user" {
 		messages = append([]map[string]interface{}{
 			{
 				"role": "user",
@@ -86,34 +149,50 @@ func build(ctx context.Context, proxies, token string, messages []map[string]int
 		}, messages...)
 	}
 
+	// 超出的停止词已由 middle.SamplingLimit 按策略处理
+	stopSequences := completion.StopSequences
+	if len(stopSequences) > maxStopSequences {
+		stopSequences = stopSequences[:maxStopSequences]
+	}
+
+	generationConfig := map[string]any{
+		"topK":            completion.TopK,
+		"topP":            completion.TopP,
+		"temperature":     completion.Temperature, // 0.8
+		"maxOutputTokens": completion.MaxTokens,
+		"candidateCount":  1, // n > 1 由 choices 多次请求
+	}
+
+	if len(stopSequences) > 0 {
+		generationConfig["stopSequences"] = stopSequences
+	}
+	if middle.NeedToFormat(completion) {
+		generationConfig["responseMimeType"] = "application/json"
+	}
+	if completion.PresencePenalty != 0 {
+		generationConfig["presencePenalty"] = completion.PresencePenalty
+	}
+	if completion.FrequencyPenalty != 0 {
+		generationConfig["frequencyPenalty"] = completion.FrequencyPenalty
+	}
+	if completion.Seed != nil {
+		generationConfig["seed"] = *completion.Seed
+	}
+
 	payload := map[string]any{
-		"contents": messages, // [ { role: user, parts: [ { text: 'xxx' } ] } ]
-		"generationConfig": map[string]any{
-			"topK":            completion.TopK,
-			"topP":            completion.TopP,
-			"temperature":     completion.Temperature, // 0.8
-			"maxOutputTokens": completion.MaxTokens,
-			"stopSequences":   []string{},
-		},
-		// 安全级别
-		"safetySettings": []map[string]string{
-			{
-				"category":  "HARM_CATEGORY_HARASSMENT",
-				"threshold": "BLOCK_NONE",
-			},
-			{
-				"category":  "HARM_CATEGORY_HATE_SPEECH",
-				"threshold": "BLOCK_NONE",
-			},
-			{
-				"category":  "HARM_CATEGORY_SEXUALLY_EXPLICIT",
-				"threshold": "BLOCK_NONE",
-			},
-			{
-				"category":  "HARM_CATEGORY_DANGEROUS_CONTENT",
-				"threshold": "BLOCK_NONE",
+		"contents":         messages, // [ { role: user, parts: [ { text: 'xxx' } ] } ]
+		"generationConfig": generationConfig,
+		"safetySettings":   safetySettings(ctx),
+	}
+
+	if system != "" {
+		payload["systemInstruction"] = map[string]interface{}{
+			"parts": []interface{}{
+				map[string]string{
+					"text": system,
+				},
 			},
-		},
+		}
 	}
 
 	if len(_funcDecls) > 0 {
@@ -124,38 +203,34 @@ func build(ctx context.Context, proxies, token string, messages []map[string]int
 			},
 		}
 	}
-	marshal, err := json.Marshal(payload)
-	if err != nil {
-		logrus.Error(err)
-		return nil, err
-	}
+	return payload
+}
 
-	res, err := emit.ClientBuilder().
-		Proxies(proxies).
-		Context(ctx).
-		POST(gURL).
-		JHeader().
-		Bytes(marshal).
-		Do()
-	if err != nil {
-		logrus.Error(err)
-		var e *url.Error
-		if errors.As(err, &e) {
-			e.URL = strings.Replace(e.URL, token, "AIzaSy***", -1)
-		}
-		return nil, err
+// 安全级别：BLOCK_NONE、BLOCK_ONLY_HIGH、BLOCK_MEDIUM_AND_ABOVE、BLOCK_LOW_AND_ABOVE
+//
+// 优先级：<safety/> 标记的分类 > 标记的 all > 配置的分类 > 配置的 all > BLOCK_NONE
+func safetySettings(ctx *gin.Context) (settings []map[string]string) {
+	var (
+		config = pkg.Config.GetStringMapString("gemini.safety")
+		flags  pkg.Keyv[interface{}]
+	)
+
+	if value, ok := ctx.Get("safety"); ok {
+		flags, _ = value.(pkg.Keyv[interface{}])
 	}
 
-	if res.StatusCode != http.StatusOK {
-		h := res.Header
-		if c := h.Get("content-type"); strings.Contains(c, "application/json") {
-			bts, e := io.ReadAll(res.Body)
-			if e == nil {
-				return nil, fmt.Errorf("%s: %s", res.Status, bts)
+	for _, category := range harmCategories {
+		threshold := "BLOCK_NONE"
+		for _, value := range []string{flags.GetString(category), flags.GetString("all"), config[category], config["all"]} {
+			if value != "" {
+				threshold = strings.ToUpper(value)
+				break
 			}
 		}
-		return nil, errors.New(res.Status)
+		settings = append(settings, map[string]string{
+			"category":  "HARM_CATEGORY_" + strings.ToUpper(category),
+			"threshold": threshold,
+		})
 	}
-
-	return res, nil
+	return
 }
@@ -11,7 +11,6 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	goole "github.com/bincooo/goole15"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"io"
 	"net/http"
 	"strings"
@@ -21,13 +20,14 @@ import (
 func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, partialResponse *http.Response, sse bool) {
 	content := ""
 	created := time.Now().Unix()
-	logrus.Infof("waitResponse ...")
+	com.Logger(ctx).Infof("waitResponse ...")
 	tokens := ctx.GetInt("tokens")
 
 	reader := bufio.NewReader(partialResponse.Body)
 	var original []byte
 	var block = []byte("data: ")
-	var functionCall interface{}
+	var toolCalls []pkg.Keyv[interface{}]
+	var tools = com.GetGinCompletion(ctx).Tools
 
 	for {
 		line, hm, err := reader.ReadLine()
@@ -41,7 +41,12 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, partialResponse *htt
 		}
 
 		if err != nil {
-			logrus.Error(err)
+			if middle.IsClosed(ctx) {
+				middle.Abandon[string](ctx, nil)
+				return
+			}
+
+			com.Logger(ctx).Error(err)
 			if middle.NotSSEHeader(ctx) {
 				middle.ErrResponse(ctx, -1, err)
 			}
@@ -54,7 +59,7 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, partialResponse *htt
 
 		if bytes.Contains(original, []byte(`"error":`)) {
 			err = fmt.Errorf("%s", original)
-			logrus.Error(err)
+			com.Logger(ctx).Error(err)
 			if middle.NotSSEHeader(ctx) {
 				middle.ErrResponse(ctx, -1, err)
 			}
@@ -68,50 +73,65 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, partialResponse *htt
 		var c candidatesResponse
 		original = bytes.TrimPrefix(original, block)
 		if err = json.Unmarshal(original, &c); err != nil {
-			logrus.Error(err)
+			com.Logger(ctx).Error(err)
+			original = nil
 			continue
 		}
 
-		cond := c.Candidates[0]
-		if cond.Content.Role != "model" {
+		// 提示词被拦截时没有 candidates
+		if len(c.Candidates) == 0 {
+			if c.PromptFeedback != nil && c.PromptFeedback.BlockReason != "" {
+				com.Logger(ctx).Warnf("gemini blocked: %s", c.PromptFeedback.BlockReason)
+				ctx.Set(vars.GinFinishReason, "content_filter")
+			}
 			original = nil
 			continue
 		}
 
-		if fc, ok := cond.Content.Parts[0]["functionCall"]; ok {
-			functionCall = fc
-			original = nil
-			continue
+		cond := c.Candidates[0]
+		if reason := finishReason(cond.FinishReason); reason != "" {
+			ctx.Set(vars.GinFinishReason, reason)
 		}
 
-		raw, ok := cond.Content.Parts[0]["text"]
-		if !ok {
-			original = nil
+		original = nil
+		if cond.Content.Role != "model" {
 			continue
 		}
-		fmt.Printf("----- raw -----\n %s\n", raw)
-		original = nil
-		raw = pkg.ExecMatchers(matchers, raw.(string))
 
-		if sse {
-			middle.SSEResponse(ctx, MODEL, raw.(string), created)
-		}
-		content += raw.(string)
+		// 同一回复中可能同时包含文本和多个函数调用
+		for _, part := range cond.Content.Parts {
+			if fc, ok := part["functionCall"].(map[string]interface{}); ok {
+				call := toolCall(tools, fc, len(toolCalls))
+				toolCalls = append(toolCalls, call)
+				if sse {
+					middle.SSEToolCallsResponse(ctx, MODEL, []pkg.Keyv[interface{}]{call}, created)
+				}
+				continue
+			}
 
+			raw, ok := part["text"].(string)
+			if !ok || raw == "" {
+				continue
+			}
+			com.LogRaw(ctx, raw)
+			raw = pkg.ExecMatchers(matchers, raw)
+			if sse {
+				middle.SSEResponse(ctx, MODEL, raw, created)
+			}
+			content += raw
+		}
 	}
 
-	if functionCall != nil {
-		fc := functionCall.(map[string]interface{})
-		args, _ := json.Marshal(fc["args"])
+	ctx.Set(vars.GinCompletionUsage, com.CalcUsageTokens(content, tokens))
+	if len(toolCalls) > 0 {
 		if sse {
-			middle.SSEToolCallResponse(ctx, MODEL, fc["name"].(string), string(args), created)
+			middle.SSEToolCallsResponse(ctx, MODEL, nil, created)
 		} else {
-			middle.ToolCallResponse(ctx, MODEL, fc["name"].(string), string(args))
+			middle.ToolCallsResponse(ctx, MODEL, content, toolCalls)
 		}
 		return
 	}
 
-	ctx.Set(vars.GinCompletionUsage, com.CalcUsageTokens(content, tokens))
 	if !sse {
 		middle.Response(ctx, MODEL, content)
 	} else {
@@ -119,21 +139,63 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, partialResponse *htt
 	}
 }
 
+// functionCall 转为 openai 的 tool_call，函数名还原为 tools 中的原名
+func toolCall(tools []pkg.Keyv[interface{}], fc map[string]interface{}, index int) pkg.Keyv[interface{}] {
+	name, _ := fc["name"].(string)
+	for _, tool := range tools {
+		if original := tool.GetKeyv("function").GetString("name"); funcName(original) == name {
+			name = original
+			break
+		}
+	}
+
+	args, _ := json.Marshal(fc["args"])
+	if fc["args"] == nil {
+		args = []byte("{}")
+	}
+	return pkg.Keyv[interface{}]{
+		"index": index,
+		"id":    "call_" + com.RandStr(5),
+		"type":  "function",
+		"function": map[string]interface{}{
+			"name":      name,
+			"arguments": string(args),
+		},
+	}
+}
+
+// gemini 的结束原因转为 openai 的 finish_reason，STOP 返回空
+func finishReason(reason string) string {
+	switch reason {
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	case "MAX_TOKENS":
+		return "length"
+	default:
+		return ""
+	}
+}
+
 func waitResponse15(ctx *gin.Context, matchers []pkg.Matcher, ch chan string, sse bool) {
 	content := ""
 	created := time.Now().Unix()
-	logrus.Infof("waitResponse ...")
+	com.Logger(ctx).Infof("waitResponse ...")
 	tokens := ctx.GetInt("tokens")
 
 	for {
-		tex, ok := <-ch
+		tex, ok := middle.Receive(ctx, ch)
+		if middle.IsClosed(ctx) {
+			middle.Abandon(ctx, ch)
+			return
+		}
+
 		if !ok {
 			break
 		}
 
 		if strings.HasPrefix(tex, "error: ") {
 			err := strings.TrimPrefix(tex, "error: ")
-			logrus.Error(err)
+			com.Logger(ctx).Error(err)
 			if middle.NotSSEHeader(ctx) {
 				middle.ErrResponse(ctx, -1, err)
 			}
@@ -142,7 +204,7 @@ func waitResponse15(ctx *gin.Context, matchers []pkg.Matcher, ch chan string, ss
 
 		if strings.HasPrefix(tex, "text: ") {
 			raw := strings.TrimPrefix(tex, "text: ")
-			fmt.Printf("----- raw -----\n %s\n", raw)
+			com.LogRaw(ctx, raw)
 			raw = pkg.ExecMatchers(matchers, raw)
 			if sse {
 				middle.SSEResponse(ctx, MODEL+"-1.5", raw, created)
@@ -159,70 +221,116 @@ func waitResponse15(ctx *gin.Context, matchers []pkg.Matcher, ch chan string, ss
 	}
 }
 
-func mergeMessages(messages []pkg.Keyv[interface{}]) (newMessages []map[string]interface{}, tokens int) {
-	// role类型转换
-	condition := func(expr string) string {
-		switch expr {
-		case "user", "system", "function":
-			return "user"
-		case "assistant":
-			return "model"
-		default:
-			return ""
+// 转换为 gemini 的 contents，相邻同角色的消息合并为多个 parts，
+// assistant 的 tool_calls 转为 functionCall，tool 消息转为 functionResponse
+//
+//	instruction: 为 true 时 system 消息单独提取为 systemInstruction，否则转为 user/model 对话
+func mergeMessages(messages []pkg.Keyv[interface{}], instruction bool) (system string, newMessages []map[string]interface{}, tokens int) {
+	var (
+		systems []string
+		names   = make(map[string]string) // tool_call_id => name
+	)
+
+	push := func(role string, parts ...interface{}) {
+		if count := len(newMessages); count > 0 && newMessages[count-1]["role"] == role {
+			newMessages[count-1]["parts"] = append(newMessages[count-1]["parts"].([]interface{}), parts...)
+			return
 		}
+		newMessages = append(newMessages, map[string]interface{}{
+			"role":  role,
+			"parts": parts,
+		})
 	}
 
-	newMessages = com.MessageCombiner(messages, func(previous, next string, message map[string]string, buffer *bytes.Buffer) []map[string]interface{} {
-		role := message["role"]
-		tokens += com.CalcTokens(message["content"])
-		if condition(role) == condition(next) {
-			// cache buffer
-			if role == "function" {
-				buffer.WriteString(fmt.Sprintf("这是系统内置tools工具的返回结果: (%s)\n\n##\n%s\n##", message["name"], message["content"]))
-				return nil
+	for index, message := range messages {
+		role := message.GetString("role")
+		content := strings.TrimSpace(message.GetString("content"))
+		tokens += com.CalcTokens(content)
+
+		switch role {
+		case "system":
+			if instruction {
+				if content != "" {
+					systems = append(systems, content)
+				}
+				continue
 			}
-			buffer.WriteString(message["content"])
-			return nil
-		}
 
-		defer buffer.Reset()
-		buffer.WriteString(fmt.Sprintf(message["content"]))
-		if role == "system" {
-			var result []map[string]interface{}
-			result = append(result, map[string]interface{}{
-				"role": "user",
-				"parts": []interface{}{
-					map[string]string{
-						"text": buffer.String(),
-					},
-				},
-			})
-			result = append(result, map[string]interface{}{
-				"role": "model",
-				"parts": []interface{}{
-					map[string]string{
-						"text": "ok ~",
-					},
+			if content != "" {
+				push("user", map[string]string{"text": content})
+			}
+			if index+1 < len(messages) && !messages[index+1].Is("role", "system") {
+				push("model", map[string]string{"text": "ok ~"})
+			}
+
+		case "tool", "function":
+			name := message.GetString("name")
+			if name == "" {
+				name = names[message.GetString("tool_call_id")]
+			}
+
+			var response interface{}
+			if json.Unmarshal([]byte(content), &response) != nil {
+				response = nil
+			}
+			if _, ok := response.(map[string]interface{}); !ok {
+				response = map[string]interface{}{"content": content}
+			}
+
+			push("user", map[string]interface{}{
+				"functionResponse": map[string]interface{}{
+					"name":     funcName(name),
+					"response": response,
 				},
 			})
-			return result
-		}
 
-		return []map[string]interface{}{
-			{
-				"role": condition(role),
-				"parts": []interface{}{
-					map[string]string{
-						"text": buffer.String(),
+		case "assistant":
+			var parts []interface{}
+			if content != "" {
+				parts = append(parts, map[string]string{"text": content})
+			}
+
+			calls, _ := message["tool_calls"].([]interface{})
+			for _, it := range calls {
+				call, ok := it.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				fn := pkg.Keyv[interface{}](call).GetKeyv("function")
+				names[pkg.Keyv[interface{}](call).GetString("id")] = fn.GetString("name")
+				var args interface{} = map[string]interface{}{}
+				if str := fn.GetString("arguments"); str != "" {
+					_ = json.Unmarshal([]byte(str), &args)
+				}
+				parts = append(parts, map[string]interface{}{
+					"functionCall": map[string]interface{}{
+						"name": funcName(fn.GetString("name")),
+						"args": args,
 					},
-				},
-			},
+				})
+			}
+
+			if len(parts) > 0 {
+				push("model", parts...)
+			}
+
+		default:
+			if content != "" {
+				push("user", map[string]string{"text": content})
+			}
 		}
-	})
+	}
 
+	system = strings.Join(systems, "\n\n")
 	return
 }
 
+// gemini 的函数名不支持 -
+func funcName(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
+
 func mergeMessages15(messages []pkg.Keyv[interface{}]) (newMessages []goole.Message, tokens int) {
 	condition := func(expr string) string {
 		switch expr {
@@ -0,0 +1,97 @@
+package middle
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+)
+
+// 响应拦截器，设置后响应不再写入客户端，而是交由拦截器处理
+//
+//	code: http状态码，流式事件恒为 http.StatusOK
+//	data: pkg.ChatResponse、gin.H 或流结束标记 "[DONE]"
+//	sse:  是否为流式事件
+type Hook func(code int, data interface{}, sse bool)
+
+// 空实现的 gin.ResponseWriter，只维护独立的 header 与状态码
+type hookWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+// 派生一个子上下文：拷贝 Keys，拥有独立的 header 与可取消的 context，
+// 适配器写出的所有响应都交由 hook 处理。
+func Fork(ctx *gin.Context, hook Hook) (*gin.Context, context.CancelFunc) {
+	child := ctx.Copy()
+	timeout, cancel := context.WithCancel(ctx.Request.Context())
+	child.Request = ctx.Request.WithContext(timeout)
+	child.Writer = &hookWriter{header: make(http.Header), status: http.StatusOK, size: -1}
+	child.Set(vars.GinHook, hook)
+	// 整形的缓存属于各自的上下文
+	delete(child.Keys, vars.GinShaper)
+	return child, cancel
+}
+
+func ginHook(ctx *gin.Context) (Hook, bool) {
+	return common.GetGinValue[Hook](ctx, vars.GinHook)
+}
+
+func (w *hookWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *hookWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *hookWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *hookWriter) WriteHeader(code int) {
+	if code > 0 && !w.Written() {
+		w.status = code
+	}
+}
+
+func (w *hookWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+	}
+}
+
+func (w *hookWriter) Status() int {
+	return w.status
+}
+
+func (w *hookWriter) Size() int {
+	return w.size
+}
+
+func (w *hookWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *hookWriter) Flush() {
+	w.WriteHeaderNow()
+}
+
+func (w *hookWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("hook writer does not support hijack")
+}
+
+func (w *hookWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *hookWriter) Pusher() http.Pusher {
+	return nil
+}
@@ -23,6 +23,10 @@ func (API) Match(_ *gin.Context, model string) bool {
 	return strings.HasPrefix(model, "lmsys/")
 }
 
+func (API) Sampling(*gin.Context, string) []string {
+	return []string{"temperature", "top_p", "max_tokens"}
+}
+
 func (API) Models() []middle.Model {
 	/*
 		// lmsys 模型导出代码
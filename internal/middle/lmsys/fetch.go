@@ -6,7 +6,7 @@ import (
 	"fmt"
 	com "github.com/bincooo/chatgpt-adapter/v2/internal/common"
 	"github.com/bincooo/emit.io"
-	"github.com/sirupsen/logrus"
+	"github.com/gin-gonic/gin"
 	"net/http"
 	"strings"
 )
@@ -32,7 +32,7 @@ type options struct {
 	fn          []int
 }
 
-func fetch(ctx context.Context, proxies, messages string, opts options) (chan string, error) {
+func fetch(ctx *gin.Context, proxies, messages string, opts options) (chan string, error) {
 	if opts.topP == 0 {
 		opts.topP = 1
 	}
@@ -56,7 +56,7 @@ func fetch(ctx context.Context, proxies, messages string, opts options) (chan st
 	return partTwo(ctx, proxies, cookies, hash, opts)
 }
 
-func partTwo(ctx context.Context, proxies, cookies, hash string, opts options) (chan string, error) {
+func partTwo(ctx *gin.Context, proxies, cookies, hash string, opts options) (chan string, error) {
 	obj := map[string]interface{}{
 		"event_data":   nil,
 		"fn_index":     opts.fn[0] + 1,
@@ -71,7 +71,7 @@ func partTwo(ctx context.Context, proxies, cookies, hash string, opts options) (
 	}
 
 	response, err := emit.ClientBuilder().
-		Context(ctx).
+		Context(ctx.Request.Context()).
 		Proxies(proxies).
 		POST(baseUrl+"/queue/join").
 		JHeader().
@@ -92,14 +92,14 @@ func partTwo(ctx context.Context, proxies, cookies, hash string, opts options) (
 	}
 
 	if eventId, ok := obj["event_id"]; ok {
-		logrus.Infof("lmsys eventId: %s", eventId)
+		com.Logger(ctx).Infof("lmsys eventId: %s", eventId)
 	} else {
 		return nil, errors.New("fetch failed")
 	}
 
 	cookies = emit.MergeCookies(cookies, emit.GetCookies(response))
 	response, err = emit.ClientBuilder().
-		Context(ctx).
+		Context(ctx.Request.Context()).
 		Proxies(proxies).
 		GET(baseUrl+"/queue/data").
 		Query("session_hash", hash).
@@ -113,7 +113,7 @@ func partTwo(ctx context.Context, proxies, cookies, hash string, opts options) (
 		return nil, err
 	}
 
-	e, err := emit.NewGio(ctx, response)
+	e, err := emit.NewGio(ctx.Request.Context(), response)
 	if err != nil {
 		return nil, err
 	}
@@ -149,7 +149,7 @@ func partTwo(ctx context.Context, proxies, cookies, hash string, opts options) (
 			if l == 2 {
 				str := items[1].(string)
 				if !strings.HasPrefix(str, "<span class=") {
-					ch <- "error: " + items[1].(string)
+					send(ctx.Request.Context(), ch, "error: "+items[1].(string))
 				}
 			}
 			return nil
@@ -170,7 +170,7 @@ func partTwo(ctx context.Context, proxies, cookies, hash string, opts options) (
 			return nil
 		}
 
-		ch <- "text: " + message[pos:]
+		send(ctx.Request.Context(), ch, "text: "+message[pos:])
 		pos = l
 		return nil
 	})
@@ -178,14 +178,22 @@ func partTwo(ctx context.Context, proxies, cookies, hash string, opts options) (
 	go func() {
 		defer close(ch)
 		if err = e.Do(); err != nil {
-			logrus.Error(err)
+			com.Logger(ctx).Error(err)
 		}
 	}()
 
 	return ch, nil
 }
 
-func partOne(ctx context.Context, proxies string, opts *options, messages string, hash string) (string, error) {
+// 客户端断开后不再阻塞在发送上
+func send(ctx context.Context, ch chan string, message string) {
+	select {
+	case ch <- message:
+	case <-ctx.Done():
+	}
+}
+
+func partOne(ctx *gin.Context, proxies string, opts *options, messages string, hash string) (string, error) {
 	obj := map[string]interface{}{
 		"event_data":   nil,
 		"session_hash": hash,
@@ -205,7 +213,7 @@ func partOne(ctx context.Context, proxies string, opts *options, messages string
 		obj["fn_index"] = fn[0]
 		obj["trigger_id"] = fn[1]
 		response, err = emit.ClientBuilder().
-			Context(ctx).
+			Context(ctx.Request.Context()).
 			Proxies(proxies).
 			POST(baseUrl+"/queue/join").
 			JHeader().
@@ -231,14 +239,14 @@ func partOne(ctx context.Context, proxies string, opts *options, messages string
 	}
 
 	if eventId, ok := obj["event_id"]; ok {
-		logrus.Infof("lmsys eventId: %s", eventId)
+		com.Logger(ctx).Infof("lmsys eventId: %s", eventId)
 	} else {
 		return "", errors.New("fetch failed")
 	}
 
 	cookies = emit.MergeCookies(cookies, emit.GetCookies(response))
 	response, err = emit.ClientBuilder().
-		Context(ctx).
+		Context(ctx.Request.Context()).
 		Proxies(proxies).
 		GET(baseUrl+"/queue/data").
 		Query("session_hash", hash).
@@ -252,7 +260,7 @@ func partOne(ctx context.Context, proxies string, opts *options, messages string
 	}
 
 	cookies = emit.MergeCookies(cookies, emit.GetCookies(response))
-	e, err := emit.NewGio(ctx, response)
+	e, err := emit.NewGio(ctx.Request.Context(), response)
 	if err != nil {
 		return "", err
 	}
@@ -275,7 +283,7 @@ func partOne(ctx context.Context, proxies string, opts *options, messages string
 	return cookies, nil
 }
 
-func fetchCookies(ctx context.Context, proxies string) (cookies string) {
+func fetchCookies(ctx *gin.Context, proxies string) (cookies string) {
 	if ver != "" {
 		cookies = fmt.Sprintf("SERVERID=%s|%s", ver, com.RandStr(5))
 		return
@@ -287,7 +295,7 @@ label:
 	}
 	retry--
 	response, err := emit.ClientBuilder().
-		Context(ctx).
+		Context(ctx.Request.Context()).
 		Proxies(proxies).
 		GET(baseUrl+"/info").
 		Header("pragma", "no-cache").
@@ -299,7 +307,7 @@ label:
 		Header("User-Agent", ua).
 		DoS(http.StatusOK)
 	if err != nil {
-		logrus.Error(err)
+		com.Logger(ctx).Error(err)
 		return
 	}
 
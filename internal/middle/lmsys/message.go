@@ -9,7 +9,6 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"strings"
 	"time"
 )
@@ -41,7 +40,7 @@ func waitMessage(chatResponse chan string, cancel func(str string) bool) (conten
 func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, chatResponse chan string, cancel chan error, sse bool) {
 	content := ""
 	created := time.Now().Unix()
-	logrus.Infof("waitResponse ...")
+	common.Logger(ctx).Infof("waitResponse ...")
 	tokens := ctx.GetInt("tokens")
 
 	for {
@@ -51,19 +50,24 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, chatResponse chan st
 				if middle.NotSSEHeader(ctx) {
 					middle.ErrResponse(ctx, -1, err)
 				}
-				logrus.Error(err)
+				common.Logger(ctx).Error(err)
 				return
 			}
 			goto label
 		default:
-			raw, ok := <-chatResponse
+			raw, ok := middle.Receive(ctx, chatResponse)
+			if middle.IsClosed(ctx) {
+				middle.Abandon(ctx, chatResponse)
+				return
+			}
+
 			if !ok {
 				goto label
 			}
 
 			if strings.HasPrefix(raw, "error: ") {
 				err := strings.TrimPrefix(raw, "error: ")
-				logrus.Error(err)
+				common.Logger(ctx).Error(err)
 				if middle.NotSSEHeader(ctx) {
 					middle.ErrResponse(ctx, -1, err)
 				}
@@ -76,9 +80,9 @@ func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, chatResponse chan st
 				continue
 			}
 
-			fmt.Printf("----- raw -----\n %s\n", raw)
+			common.LogRaw(ctx, raw)
 			raw = pkg.ExecMatchers(matchers, raw)
-			if sse && len(raw) > 0 {
+			if sse {
 				middle.SSEResponse(ctx, Model, raw, created)
 			}
 			content += raw
@@ -1,16 +1,16 @@
 package lmsys
 
 import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
 	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 )
 
 func completeToolCalls(ctx *gin.Context, proxies string, completion pkg.ChatCompletion) bool {
-	logrus.Infof("completeTools ...")
+	common.Logger(ctx).Infof("completeTools ...")
 	exec, err := middle.CompleteToolCalls(ctx, completion, func(message string) (string, error) {
-		ch, err := fetch(ctx.Request.Context(), proxies, message, options{
+		ch, err := fetch(ctx, proxies, message, options{
 			model:       completion.Model,
 			temperature: completion.Temperature,
 			topP:        completion.TopP,
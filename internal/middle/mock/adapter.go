@@ -0,0 +1,137 @@
+package mock
+
+import (
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	Adapter = API{}
+	Model   = "mock"
+)
+
+// 本地模拟模型，不访问任何上游，用于客户端调试流式输出、工具调用与错误处理
+//
+//	mock/echo:  复述最后一条 user 消息
+//	mock/text:  输出固定文本 mock.text
+//	mock/lorem: 按 mock.rate 的速率输出 mock.tokens 个 lorem 词
+//	mock/tool:  强制调用 tools 中的第一个（或 mock.tool 指定的）工具
+//	mock/error: 输出 mock.error_after 个块后中断
+//	mock/429:   直接返回 429
+//
+// 参数可在 config.yaml 的 mock 中配置，也可用 <mock rate=50 latency=500 /> 标记按请求覆盖
+type API struct {
+	middle.BaseAdapter
+}
+
+func (API) Match(_ *gin.Context, model string) bool {
+	return strings.HasPrefix(model, Model+"/")
+}
+
+func (API) Models() []middle.Model {
+	var models []middle.Model
+	for _, name := range []string{"echo", "text", "lorem", "tool", "error", "429"} {
+		models = append(models, middle.Model{
+			Id:      Model + "/" + name,
+			Object:  "model",
+			Created: 1686935002,
+			By:      Model + "-adapter",
+		})
+	}
+	return models
+}
+
+func (API) Completion(ctx *gin.Context) {
+	var (
+		completion = common.GetGinCompletion(ctx)
+		matchers   = common.GetGinMatchers(ctx)
+		s          = newScript(ctx, completion.Model)
+	)
+
+	if s.latency > 0 {
+		select {
+		case <-time.After(s.latency):
+		case <-ctx.Request.Context().Done():
+			middle.Abandon[string](ctx, nil)
+			return
+		}
+	}
+
+	if s.status != 0 {
+		middle.ErrResponse(ctx, s.status, fmt.Sprintf("mock: status %d", s.status))
+		return
+	}
+
+	tokens := calcTokens(completion.Messages)
+	if s.behavior == "tool" {
+		completeToolCall(ctx, completion, s, tokens)
+		return
+	}
+
+	content := ""
+	created := time.Now().Unix()
+	for index, chunk := range splitTokens(s.content(completion.Messages)) {
+		if s.errorAfter > 0 && index == s.errorAfter {
+			err := fmt.Errorf("mock: interrupted after %d chunks", index)
+			common.Logger(ctx).Error(err)
+			if middle.NotSSEHeader(ctx) {
+				middle.ErrResponse(ctx, -1, err)
+			}
+			return
+		}
+
+		if index > 0 && s.interval > 0 {
+			time.Sleep(s.interval)
+		}
+
+		if middle.IsClosed(ctx) {
+			middle.Abandon[string](ctx, nil)
+			return
+		}
+
+		chunk = pkg.ExecMatchers(matchers, chunk)
+		if completion.Stream {
+			middle.SSEResponse(ctx, completion.Model, chunk, created)
+		}
+		content += chunk
+	}
+
+	ctx.Set(vars.GinCompletionUsage, common.CalcUsageTokens(content, tokens))
+	if !completion.Stream {
+		middle.Response(ctx, completion.Model, content)
+	} else {
+		middle.SSEResponse(ctx, completion.Model, "[DONE]", created)
+	}
+}
+
+func completeToolCall(ctx *gin.Context, completion pkg.ChatCompletion, s script, tokens int) {
+	name := s.tool
+	if name == "" {
+		if len(completion.Tools) == 0 {
+			middle.ErrResponse(ctx, http.StatusBadRequest, "mock: no tools to call")
+			return
+		}
+		name = completion.Tools[0].GetKeyv("function").GetString("name")
+	}
+
+	ctx.Set(vars.GinCompletionUsage, common.CalcUsageTokens(s.arguments, tokens))
+	if completion.Stream {
+		middle.SSEToolCallResponse(ctx, completion.Model, name, s.arguments, time.Now().Unix())
+	} else {
+		middle.ToolCallResponse(ctx, completion.Model, name, s.arguments)
+	}
+}
+
+func calcTokens(messages []pkg.Keyv[interface{}]) (tokens int) {
+	for _, message := range messages {
+		tokens += common.CalcTokens(message.GetString("content"))
+	}
+	return
+}
@@ -0,0 +1,61 @@
+package mock
+
+import (
+	"encoding/json"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func complete(model string, stream bool, attr pkg.Keyv[interface{}]) *httptest.ResponseRecorder {
+	ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+		Model:  model,
+		Stream: stream,
+		Messages: []pkg.Keyv[interface{}]{
+			{"role": "user", "content": "hello mock"},
+		},
+		Tools: []pkg.Keyv[interface{}]{
+			{"type": "function", "function": map[string]interface{}{"name": "weather"}},
+		},
+	})
+	if attr != nil {
+		ctx.Set("mock", attr)
+	}
+	Adapter.Completion(ctx)
+	return recorder
+}
+
+func TestCompletion(t *testing.T) {
+	testutil.Config(t)
+
+	var response pkg.ChatResponse
+	recorder := complete("mock/echo", false, nil)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if content := response.Choices[0].Message.Content; content != "hello mock" {
+		t.Fatalf("echo: %q", content)
+	}
+
+	body := complete("mock/lorem", true, pkg.Keyv[interface{}]{"tokens": 5}).Body.String()
+	if strings.Count(body, "data: ") != 7 || !strings.Contains(body, "[DONE]") {
+		t.Fatalf("lorem: %s", body)
+	}
+
+	body = complete("mock/error", true, pkg.Keyv[interface{}]{"error_after": 2}).Body.String()
+	if strings.Count(body, "data: ") != 2 || strings.Contains(body, "[DONE]") {
+		t.Fatalf("error: %s", body)
+	}
+
+	body = complete("mock/tool", false, nil).Body.String()
+	if !strings.Contains(body, `"name":"weather"`) {
+		t.Fatalf("tool: %s", body)
+	}
+
+	if code := complete("mock/429", false, nil).Code; code != http.StatusTooManyRequests {
+		t.Fatalf("429: %d", code)
+	}
+}
@@ -0,0 +1,158 @@
+package mock
+
+import (
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	tokenRegexp = regexp.MustCompile(`\p{Han}|[^\s\p{Han}]+\s*|\s+`)
+
+	lorem = strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor " +
+		"incididunt ut labore et dolore magna aliqua ut enim ad minim veniam quis nostrud exercitation " +
+		"ullamco laboris nisi ut aliquip ex ea commodo consequat duis aute irure dolor in reprehenderit " +
+		"in voluptate velit esse cillum dolore eu fugiat nulla pariatur excepteur sint occaecat cupidatat " +
+		"non proident sunt in culpa qui officia deserunt mollit anim id est laborum")
+)
+
+// 一次请求的模拟行为
+type script struct {
+	behavior   string
+	text       string
+	tokens     int
+	interval   time.Duration
+	latency    time.Duration
+	errorAfter int
+	status     int
+	tool       string
+	arguments  string
+}
+
+// 读取 config.yaml 中的 mock 配置，再用 <mock /> 标记的属性覆盖
+//
+//	text:        mock/text 输出的文本
+//	rate:        每秒输出的 token 数，0 不限速
+//	tokens:      mock/lorem 输出的 token 数
+//	latency:     首次响应前的延迟（毫秒）
+//	error_after: 输出 N 个块后中断，mock/error 默认 3
+//	status:      直接返回该状态码，mock/429 默认 429
+//	tool:        mock/tool 调用的工具名
+//	arguments:   mock/tool 的调用参数
+func newScript(ctx *gin.Context, model string) script {
+	var attr pkg.Keyv[interface{}]
+	if v, ok := ctx.Get("mock"); ok {
+		attr, _ = v.(pkg.Keyv[interface{}])
+	}
+	value := func(key string) interface{} {
+		if v, ok := attr[key]; ok {
+			return v
+		}
+		return pkg.Config.Get("mock." + key)
+	}
+
+	s := script{
+		behavior:   strings.TrimPrefix(model, Model+"/"),
+		text:       toString(value("text"), "Hello, I am a mock model."),
+		tokens:     toInt(value("tokens"), 100),
+		latency:    time.Duration(toInt(value("latency"), 0)) * time.Millisecond,
+		errorAfter: toInt(value("error_after"), 0),
+		status:     toInt(value("status"), 0),
+		tool:       toString(value("tool"), ""),
+		arguments:  toString(value("arguments"), "{}"),
+	}
+
+	if rate := toInt(value("rate"), 0); rate > 0 {
+		s.interval = time.Second / time.Duration(rate)
+	}
+
+	switch s.behavior {
+	case "error":
+		if s.errorAfter <= 0 {
+			s.errorAfter = 3
+		}
+	case "429":
+		if s.status == 0 {
+			s.status = 429
+		}
+	}
+	return s
+}
+
+// 按行为生成回复内容
+func (s script) content(messages []pkg.Keyv[interface{}]) string {
+	switch s.behavior {
+	case "echo":
+		for index := len(messages) - 1; index >= 0; index-- {
+			if messages[index].Is("role", "user") {
+				return messageContent(messages[index])
+			}
+		}
+		return ""
+	case "lorem", "error":
+		words := make([]string, s.tokens)
+		for index := range words {
+			words[index] = lorem[index%len(lorem)]
+		}
+		return strings.Join(words, " ")
+	default:
+		return s.text
+	}
+}
+
+func messageContent(message pkg.Keyv[interface{}]) string {
+	if content := message.GetString("content"); content != "" {
+		return content
+	}
+
+	var texts []string
+	values, _ := message["content"].([]interface{})
+	for _, value := range values {
+		var part pkg.Keyv[interface{}]
+		switch v := value.(type) {
+		case map[string]interface{}:
+			part = v
+		case pkg.Keyv[interface{}]:
+			part = v
+		}
+		if part.Is("type", "text") {
+			texts = append(texts, part.GetString("text"))
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// 按词切分，中文按字切分
+func splitTokens(content string) []string {
+	return tokenRegexp.FindAllString(content, -1)
+}
+
+func toInt(value interface{}, def int) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+func toString(value interface{}, def string) string {
+	if value == nil {
+		return def
+	}
+	if str, ok := value.(string); ok {
+		return str
+	}
+	return fmt.Sprintf("%v", value)
+}
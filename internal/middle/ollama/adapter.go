@@ -0,0 +1,82 @@
+package ollama
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+var (
+	Adapter = API{}
+	Model   = "ollama"
+)
+
+type API struct {
+	middle.BaseAdapter
+}
+
+// ollama.baseUrl 为空时关闭
+func (API) Match(_ *gin.Context, model string) bool {
+	return baseUrl() != "" && strings.HasPrefix(model, Model+"/")
+}
+
+func (API) Sampling(*gin.Context, string) []string {
+	return []string{"temperature", "top_p", "top_k", "max_tokens", "presence_penalty", "frequency_penalty", "seed"}
+}
+
+// response_format 转为 ollama 的 format
+func (API) NativeFormat(*gin.Context, string) bool {
+	return true
+}
+
+// 模型列表取自 /api/tags，带缓存
+func (API) Models() (models []middle.Model) {
+	if baseUrl() == "" {
+		return
+	}
+
+	for _, name := range cachedTags() {
+		models = append(models, middle.Model{
+			Id:      Model + "/" + name,
+			Object:  "model",
+			Created: 1686935002,
+			By:      Model + "-adapter",
+		})
+	}
+	return
+}
+
+func (API) Completion(ctx *gin.Context) {
+	var (
+		completion = common.GetGinCompletion(ctx)
+		matchers   = common.GetGinMatchers(ctx)
+	)
+
+	messages, tokens, err := mergeMessages(ctx, completion.Messages)
+	if err != nil {
+		middle.ErrResponse(ctx, http.StatusBadRequest, err)
+		return
+	}
+	ctx.Set("tokens", tokens)
+
+	response, err := fetch(ctx, strings.TrimPrefix(completion.Model, Model+"/"), messages, completion)
+	if err != nil {
+		middle.ErrResponse(ctx, -1, err)
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		middle.ErrResponse(ctx, response.StatusCode, errorMessage(response))
+		return
+	}
+
+	waitResponse(ctx, matchers, response, completion.Model, completion.Stream)
+}
+
+func baseUrl() string {
+	return strings.TrimSuffix(pkg.Config.GetString("ollama.baseUrl"), "/")
+}
@@ -0,0 +1,190 @@
+package ollama
+
+import (
+	"encoding/json"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompletion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			_, _ = w.Write([]byte(`{"models":[{"name":"llama3:latest"},{"name":"llava:7b"}]}`))
+			return
+		}
+
+		var payload struct {
+			Model    string        `json:"model"`
+			Stream   bool          `json:"stream"`
+			Messages []chatMessage `json:"messages"`
+			Format   interface{}   `json:"format"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Model != "llava:7b" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"model not found"}`))
+			return
+		}
+
+		if len(payload.Messages[0].Images) != 1 || payload.Messages[0].Images[0] != "aGVsbG8=" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"image missing"}`))
+			return
+		}
+
+		if payload.Format == "json" {
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"{\"animal\":\"cat\"}"},"done":true,"done_reason":"stop"}`))
+			return
+		}
+
+		if payload.Stream {
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"A "},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":"cat"},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":10,"eval_count":2}` + "\n"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"weather","arguments":{"city":"Paris"}}}]},"done":true}`))
+	}))
+	defer server.Close()
+
+	testutil.Config(t).Set("ollama.baseUrl", server.URL)
+
+	models := Adapter.Models()
+	if len(models) != 2 || models[0].Id != "ollama/llama3:latest" {
+		t.Fatalf("unexpected models: %v", models)
+	}
+
+	complete := func(model string, stream bool, format ...string) *httptest.ResponseRecorder {
+		var responseFormat pkg.Keyv[interface{}]
+		if len(format) > 0 {
+			responseFormat = pkg.Keyv[interface{}]{"type": format[0]}
+		}
+		ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+			Model:          model,
+			Stream:         stream,
+			ResponseFormat: responseFormat,
+			Messages: []pkg.Keyv[interface{}]{{"role": "user", "content": []interface{}{
+				map[string]interface{}{"type": "text", "text": "what is it?"},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64,aGVsbG8="}},
+			}}},
+		})
+		Adapter.Completion(ctx)
+		return recorder
+	}
+
+	body := complete("ollama/llava:7b", true).Body.String()
+	if !strings.Contains(body, `"content":"cat"`) || !strings.Contains(body, `"total_tokens":12`) || !strings.Contains(body, "[DONE]") {
+		t.Fatalf("unexpected stream: %s", body)
+	}
+
+	body = complete("ollama/llava:7b", false).Body.String()
+	if !strings.Contains(body, `"arguments":"{\"city\":\"Paris\"}"`) || !strings.Contains(body, `"finish_reason":"tool_calls"`) {
+		t.Fatalf("unexpected tool calls: %s", body)
+	}
+
+	// json_object 转为 format: json
+	body = complete("ollama/llava:7b", false, "json_object").Body.String()
+	if !strings.Contains(body, `"content":"{\"animal\":\"cat\"}"`) {
+		t.Fatalf("unexpected format response: %s", body)
+	}
+
+	if code := complete("ollama/unknown", false).Code; code != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", code)
+	}
+}
+
+func TestDoneReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Messages []chatMessage `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		switch payload.Messages[0].Content {
+		case "long":
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"Once upon"},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"length"}` + "\n"))
+		case "cut":
+			// 未收到 done 即断开
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"Once upon"},"done":false}` + "\n"))
+		}
+	}))
+	defer server.Close()
+
+	testutil.Config(t).Set("ollama.baseUrl", server.URL)
+
+	complete := func(content string, stream bool) *httptest.ResponseRecorder {
+		ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+			Model:    "ollama/llama3",
+			Stream:   stream,
+			Messages: []pkg.Keyv[interface{}]{{"role": "user", "content": content}},
+		})
+		Adapter.Completion(ctx)
+		return recorder
+	}
+
+	for _, content := range []string{"long", "cut"} {
+		body := complete(content, true).Body.String()
+		if !strings.Contains(body, `"content":"Once upon"`) || !strings.Contains(body, `"finish_reason":"length"`) || strings.Contains(body, `"finish_reason":"stop"`) {
+			t.Fatalf("unexpected stream[%s]: %s", content, body)
+		}
+	}
+
+	if body := complete("long", false).Body.String(); !strings.Contains(body, `"finish_reason":"length"`) {
+		t.Fatalf("unexpected response: %s", body)
+	}
+	if recorder := complete("cut", false); recorder.Code != http.StatusInternalServerError || !strings.Contains(recorder.Body.String(), "unexpected EOF") {
+		t.Fatalf("unexpected response: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestModelsCache(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"models":[{"name":"llama3:latest"}]}`))
+	}))
+	defer server.Close()
+
+	// 无响应的上游
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	timeout := tagsTimeout
+	tagsTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { tagsTimeout = timeout })
+
+	config := testutil.Config(t)
+	config.Set("ollama.baseUrl", server.URL)
+	for i := 0; i < 2; i++ {
+		if models := Adapter.Models(); len(models) != 1 || calls.Load() != 1 {
+			t.Fatalf("unexpected models[%d]: %v, calls: %d", i, models, calls.Load())
+		}
+	}
+
+	// 过期后返回旧的列表，在后台刷新
+	tagsMu.Lock()
+	tags.expires = time.Now().Add(-time.Second)
+	tagsMu.Unlock()
+	if models := Adapter.Models(); len(models) != 1 {
+		t.Fatalf("unexpected models: %v", models)
+	}
+	for i := 0; calls.Load() != 2; i++ {
+		if i == 100 {
+			t.Fatal("tags not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	config.Set("ollama.baseUrl", slow.URL)
+	start := time.Now()
+	if models := Adapter.Models(); len(models) != 0 || time.Since(start) > time.Second {
+		t.Fatalf("unexpected models: %v, elapsed: %v", models, time.Since(start))
+	}
+}
@@ -0,0 +1,190 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/bincooo/emit.io"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type chatMessage struct {
+	Role      string                   `json:"role"`
+	Content   string                   `json:"content"`
+	Images    []string                 `json:"images,omitempty"`
+	ToolCalls []map[string]interface{} `json:"tool_calls,omitempty"`
+}
+
+type chatResponse struct {
+	Model           string      `json:"model"`
+	Message         chatMessage `json:"message"`
+	Done            bool        `json:"done"`
+	DoneReason      string      `json:"done_reason"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+	EvalCount       int         `json:"eval_count"`
+	Error           string      `json:"error"`
+}
+
+// 请求 /api/chat，流式响应为 NDJSON
+//
+//	ollama.keep_alive: 模型在内存中的保留时间，如 5m
+func fetch(ctx *gin.Context, model string, messages []chatMessage, completion pkg.ChatCompletion) (*http.Response, error) {
+	options := make(map[string]interface{})
+	if completion.Temperature != 0 {
+		options["temperature"] = completion.Temperature
+	}
+	if completion.TopP != 0 {
+		options["top_p"] = completion.TopP
+	}
+	if completion.TopK != 0 {
+		options["top_k"] = completion.TopK
+	}
+	if completion.MaxTokens > 0 {
+		options["num_predict"] = completion.MaxTokens
+	}
+	if completion.PresencePenalty != 0 {
+		options["presence_penalty"] = completion.PresencePenalty
+	}
+	if completion.FrequencyPenalty != 0 {
+		options["frequency_penalty"] = completion.FrequencyPenalty
+	}
+	if completion.Seed != nil {
+		options["seed"] = *completion.Seed
+	}
+	if len(completion.StopSequences) > 0 {
+		options["stop"] = completion.StopSequences
+	}
+
+	payload := map[string]interface{}{
+		"model":    model,
+		"messages": messages,
+		"stream":   completion.Stream,
+	}
+	if len(options) > 0 {
+		payload["options"] = options
+	}
+	if len(completion.Tools) > 0 {
+		payload["tools"] = completion.Tools
+	}
+	// json_object 为 "json"，json_schema 直接传入 schema
+	switch completion.ResponseFormat.GetString("type") {
+	case "json_object":
+		payload["format"] = "json"
+	case "json_schema":
+		if schema := completion.ResponseFormat.GetKeyv("json_schema").GetKeyv("schema"); schema != nil {
+			payload["format"] = schema
+		} else {
+			payload["format"] = "json"
+		}
+	}
+	if keepAlive := pkg.Config.GetString("ollama.keep_alive"); keepAlive != "" {
+		payload["keep_alive"] = keepAlive
+	}
+
+	marshal, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return emit.ClientBuilder().
+		Context(ctx.Request.Context()).
+		POST(baseUrl() + "/api/chat").
+		JHeader().
+		Bytes(marshal).
+		Do()
+}
+
+// 模型列表的缓存，baseUrl 变化时重新获取
+type tagCache struct {
+	baseUrl    string
+	names      []string
+	expires    time.Time
+	refreshing bool
+}
+
+var (
+	tagsMu sync.Mutex
+	tags   tagCache
+
+	tagsTTL     = time.Minute
+	tagsTimeout = 2 * time.Second
+)
+
+// 本地已下载的模型：缓存 tagsTTL，过期后先返回旧的列表并在后台刷新，只有首次获取时等待
+func cachedTags() []string {
+	u := baseUrl()
+	tagsMu.Lock()
+	if tags.baseUrl == u && !tags.expires.IsZero() {
+		names := tags.names
+		if time.Now().After(tags.expires) && !tags.refreshing {
+			tags.refreshing = true
+			go refreshTags(u)
+		}
+		tagsMu.Unlock()
+		return names
+	}
+	tagsMu.Unlock()
+	return refreshTags(u)
+}
+
+// 获取失败时保留旧的列表，同样等到过期后再重试
+func refreshTags(u string) []string {
+	names, err := fetchTags(u)
+	tagsMu.Lock()
+	defer tagsMu.Unlock()
+	if err != nil {
+		logrus.Warnf("ollama tags: %v", err)
+		if tags.baseUrl == u {
+			names = tags.names
+		}
+	}
+	tags = tagCache{baseUrl: u, names: names, expires: time.Now().Add(tagsTTL)}
+	return names
+}
+
+func fetchTags(u string) (names []string, err error) {
+	timeout, cancel := context.WithTimeout(context.Background(), tagsTimeout)
+	defer cancel()
+
+	response, err := emit.ClientBuilder().
+		Context(timeout).
+		GET(u + "/api/tags").
+		DoS(http.StatusOK)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	var r struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&r); err != nil {
+		return
+	}
+
+	for _, model := range r.Models {
+		names = append(names, model.Name)
+	}
+	return
+}
+
+func errorMessage(response *http.Response) string {
+	data, err := io.ReadAll(response.Body)
+	if err != nil || len(data) == 0 {
+		return response.Status
+	}
+
+	var r chatResponse
+	if json.Unmarshal(data, &r) == nil && r.Error != "" {
+		return r.Error
+	}
+	return fmt.Sprintf("%s: %s", response.Status, data)
+}
@@ -0,0 +1,214 @@
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 转换为 ollama 的消息格式：图片放入 images，tool_calls 的参数转为对象
+func mergeMessages(ctx *gin.Context, messages []pkg.Keyv[interface{}]) (newMessages []chatMessage, tokens int, err error) {
+	for _, message := range messages {
+		role := message.GetString("role")
+		if role == "function" {
+			role = "tool"
+		}
+
+		msg := chatMessage{Role: role}
+		switch content := message["content"].(type) {
+		case string:
+			msg.Content = content
+		case []interface{}:
+			var texts []string
+			for _, it := range content {
+				part, ok := it.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				switch part["type"] {
+				case "text":
+					text, _ := part["text"].(string)
+					texts = append(texts, text)
+				case "image_url":
+					// ollama 只接受 base64 图片
+					_, image, e := common.LoadImage(ctx.Request.Context(), ctx.GetString("proxies"), imageUrl(part["image_url"]))
+					if e != nil {
+						return nil, 0, e
+					}
+					msg.Images = append(msg.Images, image)
+				}
+			}
+			msg.Content = strings.Join(texts, "\n")
+		}
+
+		if calls, ok := message["tool_calls"].([]interface{}); ok {
+			for _, it := range calls {
+				call, o := it.(map[string]interface{})
+				if !o {
+					continue
+				}
+
+				fn, _ := call["function"].(map[string]interface{})
+				var args interface{} = map[string]interface{}{}
+				if str, k := fn["arguments"].(string); k && str != "" {
+					if e := json.Unmarshal([]byte(str), &args); e != nil {
+						return nil, 0, fmt.Errorf("tool_calls arguments: %v", e)
+					}
+				}
+				msg.ToolCalls = append(msg.ToolCalls, map[string]interface{}{
+					"function": map[string]interface{}{
+						"name":      fn["name"],
+						"arguments": args,
+					},
+				})
+			}
+		}
+
+		tokens += common.CalcTokens(msg.Content)
+		newMessages = append(newMessages, msg)
+	}
+	return
+}
+
+func imageUrl(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		str, _ := v["url"].(string)
+		return str
+	}
+	return ""
+}
+
+// 转为 OpenAI 格式的 tool_calls，参数对象序列化为字符串
+func toolCalls(calls []map[string]interface{}, offset int, sse bool) (values []pkg.Keyv[interface{}]) {
+	for index, call := range calls {
+		fn, _ := call["function"].(map[string]interface{})
+		args, _ := json.Marshal(fn["arguments"])
+		value := pkg.Keyv[interface{}]{
+			"id":   "call_" + common.RandStr(5),
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":      fn["name"],
+				"arguments": string(args),
+			},
+		}
+		if sse {
+			value["index"] = offset + index
+		}
+		values = append(values, value)
+	}
+	return
+}
+
+func waitResponse(ctx *gin.Context, matchers []pkg.Matcher, response *http.Response, model string, sse bool) {
+	content := ""
+	created := time.Now().Unix()
+	common.Logger(ctx).Infof("waitResponse ...")
+	tokens := ctx.GetInt("tokens")
+
+	var (
+		calls   []pkg.Keyv[interface{}]
+		usage   map[string]int
+		decoder = json.NewDecoder(response.Body)
+		done    = false
+		err     error
+	)
+
+	for {
+		var r chatResponse
+		if err = decoder.Decode(&r); err != nil {
+			break
+		}
+
+		if r.Error != "" {
+			common.Logger(ctx).Error(r.Error)
+			if middle.NotSSEHeader(ctx) {
+				middle.ErrResponse(ctx, -1, r.Error)
+			}
+			return
+		}
+
+		if raw := r.Message.Content; raw != "" {
+			common.LogRaw(ctx, raw)
+			raw = pkg.ExecMatchers(matchers, raw)
+			if sse {
+				middle.SSEResponse(ctx, model, raw, created)
+			}
+			content += raw
+		}
+
+		if len(r.Message.ToolCalls) > 0 {
+			values := toolCalls(r.Message.ToolCalls, len(calls), sse)
+			calls = append(calls, values...)
+			if sse {
+				middle.SSEToolCallsResponse(ctx, model, values, created)
+			}
+		}
+
+		if r.Done {
+			done = true
+			if r.DoneReason == "length" {
+				ctx.Set(vars.GinFinishReason, "length")
+			}
+			if r.EvalCount > 0 {
+				usage = map[string]int{
+					"prompt_tokens":     r.PromptEvalCount,
+					"completion_tokens": r.EvalCount,
+					"total_tokens":      r.PromptEvalCount + r.EvalCount,
+				}
+			}
+			break
+		}
+	}
+
+	// 未收到 done 即结束：未开始输出时返回错误，否则以 length 结束
+	truncated := false
+	if !done {
+		if middle.IsClosed(ctx) {
+			middle.Abandon[string](ctx, nil)
+			return
+		}
+
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		common.Logger(ctx).Error(err)
+		if middle.NotSSEHeader(ctx) {
+			middle.ErrResponse(ctx, -1, err)
+			return
+		}
+		truncated = true
+		ctx.Set(vars.GinFinishReason, "length")
+	}
+
+	if usage == nil {
+		usage = common.CalcUsageTokens(content, tokens)
+	}
+	ctx.Set(vars.GinCompletionUsage, usage)
+
+	if len(calls) > 0 && !truncated {
+		if sse {
+			middle.SSEToolCallsResponse(ctx, model, nil, created)
+		} else {
+			middle.ToolCallsResponse(ctx, model, content, calls)
+		}
+		return
+	}
+
+	if !sse {
+		middle.Response(ctx, model, content)
+	} else {
+		middle.SSEResponse(ctx, model, "[DONE]", created)
+	}
+}
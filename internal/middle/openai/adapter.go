@@ -0,0 +1,119 @@
+package openai
+
+import (
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"net/http"
+	"strings"
+)
+
+var (
+	Adapter = API{}
+	Model   = "openai"
+)
+
+// OpenAI 兼容的上游，如 vLLM、llama.cpp server、one-api
+type upstream struct {
+	Name    string            `mapstructure:"name"`
+	BaseUrl string            `mapstructure:"baseUrl"`
+	Prefix  string            `mapstructure:"prefix"`
+	Key     string            `mapstructure:"key"`
+	Headers map[string]string `mapstructure:"headers"`
+	Models  []string          `mapstructure:"models"`
+	Timeout int               `mapstructure:"timeout"`
+	Proxies bool              `mapstructure:"proxies"`
+}
+
+type API struct {
+	middle.BaseAdapter
+}
+
+func (API) Match(ctx *gin.Context, model string) bool {
+	_, _, ok := matchUpstream(ctx, model)
+	return ok
+}
+
+// response_format 原样转发给上游
+func (API) NativeFormat(*gin.Context, string) bool {
+	return true
+}
+
+func (API) Models() (models []middle.Model) {
+	for _, u := range upstreams.Get(nil) {
+		for _, model := range u.Models {
+			models = append(models, middle.Model{
+				Id:      u.Prefix + "/" + model,
+				Object:  "model",
+				Created: 1686935002,
+				By:      u.Name + "-adapter",
+			})
+		}
+	}
+	return
+}
+
+func (API) Completion(ctx *gin.Context) {
+	var (
+		completion = common.GetGinCompletion(ctx)
+		matchers   = common.GetGinMatchers(ctx)
+	)
+
+	u, model, _ := matchUpstream(ctx, completion.Model)
+	response, cancel, err := fetch(ctx, u, model, completion)
+	if err != nil {
+		middle.ErrResponse(ctx, -1, err)
+		return
+	}
+	defer cancel()
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		middle.ErrResponse(ctx, response.StatusCode, errorMessage(response))
+		return
+	}
+
+	ctx.Set("tokens", calcTokens(completion.Messages))
+	if completion.Stream {
+		WaitSSEResponse(ctx, matchers, response, completion.Model)
+		return
+	}
+	WaitResponse(ctx, matchers, response, completion.Model)
+}
+
+// 配置中的上游列表，解析结果随配置缓存
+//
+//	openai:
+//	  - name: vllm
+//	    baseUrl: http://127.0.0.1:8000/v1
+//	    prefix: vllm
+var upstreams = common.NewConfigValue(func(config *viper.Viper) (values []upstream, err error) {
+	if err = config.UnmarshalKey("openai", &values); err != nil {
+		return nil, fmt.Errorf("openai: %v", err)
+	}
+
+	for index := range values {
+		if values[index].Prefix == "" {
+			values[index].Prefix = values[index].Name
+		}
+		values[index].BaseUrl = strings.TrimSuffix(values[index].BaseUrl, "/")
+	}
+	return
+})
+
+// 按前缀匹配上游，返回去掉前缀后的模型名。models 为空时接受该前缀下的任意模型
+func matchUpstream(ctx *gin.Context, model string) (upstream, string, bool) {
+	for _, u := range upstreams.Get(ctx) {
+		if u.Prefix == "" || !strings.HasPrefix(model, u.Prefix+"/") {
+			continue
+		}
+
+		name := strings.TrimPrefix(model, u.Prefix+"/")
+		if len(u.Models) == 0 || common.Contains(u.Models, name) {
+			return u, name, true
+		}
+	}
+	return upstream{}, "", false
+}
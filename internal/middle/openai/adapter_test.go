@@ -0,0 +1,175 @@
+package openai
+
+import (
+	"encoding/json"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompletion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &payload)
+		if payload["model"] != "qwen" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"message":"bad request"}}`))
+			return
+		}
+
+		if format, ok := payload["response_format"].(map[string]interface{}); ok {
+			if format["type"] != "json_object" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"{\"ok\":true}"},"finish_reason":"stop"}]}`))
+			return
+		}
+
+		if payload["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(`data: {"choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"}}]}` + "\n\n" +
+				`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":""}}]}}]}` + "\n\n" +
+				`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}}]}}]}` + "\n\n" +
+				"data: [DONE]\n\n"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"Hello"}}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`))
+	}))
+	defer server.Close()
+
+	config := testutil.Config(t)
+	config.Set("openai", []interface{}{
+		map[string]interface{}{"name": "local", "baseUrl": server.URL, "key": "sk-test", "models": []string{"qwen"}},
+	})
+
+	if !Adapter.Match(nil, "local/qwen") || Adapter.Match(nil, "local/other") {
+		t.Fatal("unexpected match")
+	}
+
+	complete := func(stream bool) string {
+		ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+			Model:    "local/qwen",
+			Stream:   stream,
+			Messages: []pkg.Keyv[interface{}]{{"role": "user", "content": "hi"}},
+		})
+		Adapter.Completion(ctx)
+		return recorder.Body.String()
+	}
+
+	if body := complete(false); !strings.Contains(body, `"content":"Hello"`) || !strings.Contains(body, `"total_tokens":4`) {
+		t.Fatalf("unexpected response: %s", body)
+	}
+
+	body := complete(true)
+	for _, expected := range []string{`"content":"Hi"`, `"name":"weather"`, `"finish_reason":"tool_calls"`, "[DONE]"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("missing %s: %s", expected, body)
+		}
+	}
+
+	// response_format 原样转发给上游
+	ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+		Model:          "local/qwen",
+		Messages:       []pkg.Keyv[interface{}]{{"role": "user", "content": "hi"}},
+		ResponseFormat: pkg.Keyv[interface{}]{"type": "json_object"},
+	})
+	Adapter.Completion(ctx)
+	if body = recorder.Body.String(); !strings.Contains(body, `"content":"{\"ok\":true}"`) {
+		t.Fatalf("unexpected response: %s", body)
+	}
+}
+
+func TestReasoning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &payload)
+		if payload["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(`data: {"choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"1 + 1"}}]}` + "\n\n" +
+				`data: {"choices":[{"index":0,"delta":{"content":"2"}}]}` + "\n\n" +
+				"data: [DONE]\n\n"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","reasoning_content":"1 + 1","content":"2"}}]}`))
+	}))
+	defer server.Close()
+
+	config := testutil.Config(t)
+	config.Set("openai", []interface{}{
+		map[string]interface{}{"name": "local", "baseUrl": server.URL},
+	})
+
+	complete := func(stream bool) string {
+		ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+			Model:    "local/qwen",
+			Stream:   stream,
+			Messages: []pkg.Keyv[interface{}]{{"role": "user", "content": "1 + 1 = ?"}},
+		})
+		Adapter.Completion(ctx)
+		return recorder.Body.String()
+	}
+
+	// 默认原样透传到 reasoning_content
+	for _, stream := range []bool{false, true} {
+		body := complete(stream)
+		if !strings.Contains(body, `"reasoning_content":"1 + 1"`) || strings.Contains(body, "think") {
+			t.Fatalf("unexpected response: %s", body)
+		}
+	}
+
+	// 开启 wrap_native 后包装为思考块，inline 模式留在正文中
+	config.Set("reasoning.wrap_native", true)
+	for _, stream := range []bool{false, true} {
+		body := complete(stream)
+		if strings.Contains(body, "reasoning_content") || !strings.Contains(body, "think") {
+			t.Fatalf("unexpected response: %s", body)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		messages, _ := payload["messages"].([]interface{})
+		w.Header().Set("Content-Type", "text/event-stream")
+		// 未收到 [DONE] 与 finish_reason 即断开
+		if messages[0].(map[string]interface{})["content"] == "empty" {
+			_, _ = w.Write([]byte(`data: {"choices":[{"index":0,"delta":{"role":"assistant"}}]}` + "\n\n"))
+			return
+		}
+		_, _ = w.Write([]byte(`data: {"choices":[{"index":0,"delta":{"role":"assistant","content":"Once upon"}}]}` + "\n\n"))
+	}))
+	defer server.Close()
+
+	testutil.Config(t).Set("openai", []interface{}{
+		map[string]interface{}{"name": "local", "baseUrl": server.URL},
+	})
+
+	complete := func(content string) (int, string) {
+		ctx, recorder := testutil.Completion(pkg.ChatCompletion{
+			Model:    "local/qwen",
+			Stream:   true,
+			Messages: []pkg.Keyv[interface{}]{{"role": "user", "content": content}},
+		})
+		Adapter.Completion(ctx)
+		return recorder.Code, recorder.Body.String()
+	}
+
+	code, body := complete("hi")
+	if code != http.StatusOK || !strings.Contains(body, `"content":"Once upon"`) || !strings.Contains(body, `"finish_reason":"length"`) || strings.Contains(body, `"finish_reason":"stop"`) {
+		t.Fatalf("unexpected response: %s", body)
+	}
+
+	code, body = complete("empty")
+	if code != http.StatusInternalServerError || !strings.Contains(body, "unexpected EOF") {
+		t.Fatalf("unexpected response: %d %s", code, body)
+	}
+}
@@ -0,0 +1,121 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/bincooo/emit.io"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"time"
+)
+
+// 组装 OpenAI 格式的请求体，只携带已设置的参数，避免严格校验的上游报错
+func NewPayload(model string, completion pkg.ChatCompletion) map[string]interface{} {
+	payload := map[string]interface{}{
+		"model":    model,
+		"messages": completion.Messages,
+		"stream":   completion.Stream,
+	}
+
+	if completion.MaxTokens > 0 {
+		payload["max_tokens"] = completion.MaxTokens
+	}
+	if completion.Temperature != 0 {
+		payload["temperature"] = completion.Temperature
+	}
+	if completion.TopP != 0 {
+		payload["top_p"] = completion.TopP
+	}
+	if completion.TopK != 0 {
+		payload["top_k"] = completion.TopK
+	}
+	if completion.PresencePenalty != 0 {
+		payload["presence_penalty"] = completion.PresencePenalty
+	}
+	if completion.FrequencyPenalty != 0 {
+		payload["frequency_penalty"] = completion.FrequencyPenalty
+	}
+	if completion.Seed != nil {
+		payload["seed"] = *completion.Seed
+	}
+	if len(completion.LogitBias) > 0 {
+		payload["logit_bias"] = completion.LogitBias
+	}
+	if completion.User != "" {
+		payload["user"] = completion.User
+	}
+	if len(completion.StopSequences) > 0 {
+		payload["stop"] = completion.StopSequences
+	}
+	if len(completion.ResponseFormat) > 0 {
+		payload["response_format"] = completion.ResponseFormat
+	}
+	if len(completion.Tools) > 0 {
+		payload["tools"] = completion.Tools
+		if completion.ToolChoice != "" {
+			payload["tool_choice"] = completion.ToolChoice
+		}
+	}
+	return payload
+}
+
+// 请求上游，timeout 为等待响应头的超时（秒），读取流式响应不受限制
+func fetch(ctx *gin.Context, u upstream, model string, completion pkg.ChatCompletion) (*http.Response, context.CancelFunc, error) {
+	marshal, err := json.Marshal(NewPayload(model, completion))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := u.Key
+	if key == "" {
+		key = ctx.GetString("token")
+	}
+
+	proxies := ""
+	if u.Proxies {
+		proxies = ctx.GetString("proxies")
+	}
+
+	reqCtx, cancel := context.WithCancel(ctx.Request.Context())
+	if u.Timeout > 0 {
+		timer := time.AfterFunc(time.Duration(u.Timeout)*time.Second, cancel)
+		defer timer.Stop()
+	}
+
+	builder := emit.ClientBuilder().
+		Proxies(proxies).
+		Context(reqCtx).
+		POST(u.BaseUrl + "/chat/completions").
+		JHeader().
+		Bytes(marshal)
+	if key != "" {
+		builder.Header("Authorization", "Bearer "+key)
+	}
+	for k, v := range u.Headers {
+		builder.Header(k, v)
+	}
+
+	response, err := builder.Do()
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return response, cancel, nil
+}
+
+// 读取上游的错误信息
+func errorMessage(response *http.Response) string {
+	data, err := io.ReadAll(response.Body)
+	if err != nil || len(data) == 0 {
+		return response.Status
+	}
+
+	var r pkg.ChatResponse
+	if json.Unmarshal(data, &r) == nil && r.Error != nil && r.Error.Message != "" {
+		return r.Error.Message
+	}
+	return fmt.Sprintf("%s: %s", response.Status, data)
+}
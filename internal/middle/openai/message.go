@@ -0,0 +1,218 @@
+package openai
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/middle"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func calcTokens(messages []pkg.Keyv[interface{}]) (tokens int) {
+	for _, message := range messages {
+		tokens += common.CalcTokens(message.GetString("content"))
+	}
+	return
+}
+
+// 上游原生的 reasoning_content 默认原样输出到 reasoning_content，
+// 开启 reasoning.wrap_native 时包装为思考块，交由 reasoning.mode 统一处理
+func wrapReasoning() bool {
+	return pkg.Config.GetBool("reasoning.wrap_native")
+}
+
+func reasoningTag() string {
+	tags := pkg.Config.GetStringSlice("reasoning.tags")
+	if len(tags) == 0 {
+		return "think"
+	}
+	return tags[0]
+}
+
+// 记录上游的结束原因，tool_calls 由响应方法自行处理
+func finishReason(ctx *gin.Context, reason *string) {
+	if reason != nil && *reason != "" && *reason != "tool_calls" && *reason != "stop" {
+		ctx.Set(vars.GinFinishReason, *reason)
+	}
+}
+
+// 读取 OpenAI 格式的非流式响应，ctx 中的 tokens 为提示词的 token 数，用于上游未返回 usage 时估算
+func WaitResponse(ctx *gin.Context, matchers []pkg.Matcher, response *http.Response, model string) {
+	var r pkg.ChatResponse
+	if err := json.NewDecoder(response.Body).Decode(&r); err != nil {
+		middle.ErrResponse(ctx, -1, err)
+		return
+	}
+
+	if r.Error != nil {
+		middle.ErrResponse(ctx, -1, r.Error.Message)
+		return
+	}
+
+	if len(r.Choices) == 0 || r.Choices[0].Message == nil {
+		middle.ErrResponse(ctx, -1, "empty response")
+		return
+	}
+
+	finishReason(ctx, r.Choices[0].FinishReason)
+	message := r.Choices[0].Message
+	content := message.Content
+	if !wrapReasoning() {
+		middle.AppendReasoning(ctx, message.ReasoningContent)
+	} else if message.ReasoningContent != "" {
+		tag := reasoningTag()
+		content = "<" + tag + ">" + message.ReasoningContent + "</" + tag + ">" + content
+	}
+
+	common.LogRaw(ctx, content)
+	content = pkg.ExecMatchers(matchers, content)
+
+	usage := r.Usage
+	if usage == nil {
+		usage = common.CalcUsageTokens(content, ctx.GetInt("tokens"))
+	}
+	ctx.Set(vars.GinCompletionUsage, usage)
+
+	if len(message.ToolCalls) > 0 {
+		middle.ToolCallsResponse(ctx, model, content, message.ToolCalls)
+		return
+	}
+	middle.Response(ctx, model, content)
+}
+
+// 读取 OpenAI 格式的流式响应，content_filter、length 等结束原因透传给客户端
+func WaitSSEResponse(ctx *gin.Context, matchers []pkg.Matcher, response *http.Response, model string) {
+	var (
+		content   = ""
+		created   = time.Now().Unix()
+		tag       = reasoningTag()
+		wrap      = wrapReasoning()
+		thinking  = false
+		toolCall  = false
+		usage     map[string]int
+		scanner   = bufio.NewScanner(response.Body)
+		completed = false
+		finished  = false // 收到了 finish_reason
+	)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4<<20)
+
+	for scanner.Scan() {
+		text := scanner.Text()
+		if !strings.HasPrefix(text, "data:") {
+			continue
+		}
+
+		text = strings.TrimSpace(text[5:])
+		if text == "[DONE]" {
+			completed = true
+			break
+		}
+
+		var r pkg.ChatResponse
+		if err := json.Unmarshal([]byte(text), &r); err != nil {
+			common.Logger(ctx).Warnf("openai: %v: %s", err, text)
+			continue
+		}
+
+		if r.Error != nil {
+			common.Logger(ctx).Error(r.Error.Message)
+			if middle.NotSSEHeader(ctx) {
+				middle.ErrResponse(ctx, -1, r.Error.Message)
+			}
+			return
+		}
+
+		if r.Usage != nil {
+			usage = r.Usage
+		}
+
+		if len(r.Choices) == 0 {
+			continue
+		}
+
+		if reason := r.Choices[0].FinishReason; reason != nil && *reason != "" {
+			finished = true
+		}
+		finishReason(ctx, r.Choices[0].FinishReason)
+		if r.Choices[0].Delta == nil {
+			continue
+		}
+
+		delta := r.Choices[0].Delta
+		raw := ""
+		if !wrap {
+			middle.AppendReasoning(ctx, delta.ReasoningContent)
+		} else if delta.ReasoningContent != "" {
+			if !thinking {
+				raw = "<" + tag + ">"
+				thinking = true
+			}
+			raw += delta.ReasoningContent
+		}
+
+		if thinking && (delta.Content != "" || len(delta.ToolCalls) > 0) {
+			raw += "</" + tag + ">"
+			thinking = false
+		}
+		raw += delta.Content
+
+		if raw != "" {
+			common.LogRaw(ctx, raw)
+			raw = pkg.ExecMatchers(matchers, raw)
+			middle.SSEResponse(ctx, model, raw, created)
+			content += raw
+		} else if !wrap && delta.ReasoningContent != "" {
+			middle.SSEResponse(ctx, model, "", created)
+		}
+
+		if len(delta.ToolCalls) > 0 {
+			toolCall = true
+			middle.SSEToolCallsResponse(ctx, model, delta.ToolCalls, created)
+		}
+	}
+
+	if !completed && middle.IsClosed(ctx) {
+		middle.Abandon[string](ctx, nil)
+		return
+	}
+
+	// 连接中断或行超出缓冲区：未开始输出时返回错误，否则以 length 结束，不当作正常完成
+	truncated := false
+	if err := scanner.Err(); err != nil || !completed && !finished {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		common.Logger(ctx).Errorf("openai: %v", err)
+		if !finished {
+			if middle.NotSSEHeader(ctx) {
+				middle.ErrResponse(ctx, -1, err)
+				return
+			}
+			truncated = true
+			ctx.Set(vars.GinFinishReason, "length")
+		}
+	}
+
+	if thinking {
+		raw := pkg.ExecMatchers(matchers, "</"+tag+">")
+		middle.SSEResponse(ctx, model, raw, created)
+		content += raw
+	}
+
+	if usage == nil {
+		usage = common.CalcUsageTokens(content, ctx.GetInt("tokens"))
+	}
+	ctx.Set(vars.GinCompletionUsage, usage)
+
+	if toolCall && !truncated {
+		middle.SSEToolCallsResponse(ctx, model, nil, created)
+		return
+	}
+	middle.SSEResponse(ctx, model, "[DONE]", created)
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/bincooo/emit.io"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/sirupsen/logrus"
 	"io"
 	"math/rand"
 	"net/http"
@@ -191,7 +190,7 @@ func (API) Generation(ctx *gin.Context) {
 	if (generation.Size == "HD" || strings.HasPrefix(generation.Size, "1792x")) && com.HasMfy() {
 		v, e := com.Magnify(ctx, file)
 		if e != nil {
-			logrus.Error(e)
+			com.Logger(ctx).Error(e)
 		} else {
 			file = v
 		}
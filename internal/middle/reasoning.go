@@ -0,0 +1,80 @@
+package middle
+
+import (
+	"bytes"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ReasoningInline   = "inline"   // 保持原样输出
+	ReasoningStrip    = "strip"    // 删除思考块
+	ReasoningSeparate = "separate" // 分离到 reasoning_content
+)
+
+// 思考块匹配器，优先使用 <reasoning mode="xxx" /> 标记，其次是配置 reasoning.mode
+//
+//	inline 模式返回 nil
+func ReasoningMatcher(ctx *gin.Context) pkg.Matcher {
+	mode := ReasoningMode(ctx)
+	tags := pkg.Config.GetStringSlice("reasoning.tags")
+	if len(tags) == 0 {
+		tags = []string{"think"}
+	}
+
+	switch mode {
+	case ReasoningStrip:
+		return &pkg.ReasoningMatcher{Tags: tags}
+	case ReasoningSeparate:
+		buffer := new(bytes.Buffer)
+		ctx.Set(vars.GinReasoning, buffer)
+		return &pkg.ReasoningMatcher{
+			Tags: tags,
+			H: func(reasoning string) {
+				buffer.WriteString(reasoning)
+			},
+		}
+	default:
+		return nil
+	}
+}
+
+// 思考块的处理模式，优先使用 <reasoning mode="xxx" /> 标记，其次是配置 reasoning.mode
+func ReasoningMode(ctx *gin.Context) string {
+	if mode := ctx.GetString("reasoning"); mode != "" {
+		return mode
+	}
+	return pkg.Config.GetString("reasoning.mode")
+}
+
+// 上游原生的思考内容，随下一次响应输出到 reasoning_content，strip 模式下丢弃
+func AppendReasoning(ctx *gin.Context, reasoning string) {
+	if reasoning == "" || ReasoningMode(ctx) == ReasoningStrip {
+		return
+	}
+
+	buffer, ok := common.GetGinValue[*bytes.Buffer](ctx, vars.GinReasoning)
+	if !ok {
+		buffer = new(bytes.Buffer)
+		ctx.Set(vars.GinReasoning, buffer)
+	}
+	buffer.WriteString(reasoning)
+}
+
+// 取出已分离但未输出的思考内容
+func takeReasoning(ctx *gin.Context) string {
+	buffer, ok := common.GetGinValue[*bytes.Buffer](ctx, vars.GinReasoning)
+	if !ok || buffer.Len() == 0 {
+		return ""
+	}
+
+	defer buffer.Reset()
+	return buffer.String()
+}
+
+// 输出结束时清空匹配器缓存的尾部（如未闭合的思考块、不完整的标签），返回需要追加的正文
+func flushMatchers(ctx *gin.Context) string {
+	return pkg.FlushMatchers(common.GetGinMatchers(ctx))
+}
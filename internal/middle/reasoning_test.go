@@ -0,0 +1,53 @@
+package middle
+
+import (
+	"encoding/json"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"testing"
+	"time"
+)
+
+func TestReasoningFlush(t *testing.T) {
+	testutil.Config(t).Set("reasoning.mode", ReasoningSeparate)
+
+	// 流式：未闭合的思考块在结束时输出
+	ctx, recorder := testutil.Completion(pkg.ChatCompletion{Stream: true})
+	matchers := []pkg.Matcher{ReasoningMatcher(ctx)}
+	ctx.Set(vars.GinMatchers, matchers)
+
+	created := time.Now().Unix()
+	for _, chunk := range []string{"<think>a", "b</th"} {
+		SSEResponse(ctx, "mock", pkg.ExecMatchers(matchers, chunk), created)
+	}
+	SSEResponse(ctx, "mock", "[DONE]", created)
+
+	responses, done := sseEvents(t, recorder.Body.String())
+	reasoning := ""
+	for _, response := range responses {
+		if delta := response.Choices[0].Delta; delta != nil {
+			reasoning += delta.ReasoningContent
+			if delta.Content != "" {
+				t.Fatalf("unexpected content: %s", recorder.Body.String())
+			}
+		}
+	}
+	if reasoning != "ab</th" || done != 1 {
+		t.Fatalf("unexpected reasoning: %s", recorder.Body.String())
+	}
+
+	// 非流式：不完整的开始标签作为正文输出
+	ctx, recorder = testutil.Completion(pkg.ChatCompletion{})
+	matchers = []pkg.Matcher{ReasoningMatcher(ctx)}
+	ctx.Set(vars.GinMatchers, matchers)
+	Response(ctx, "mock", pkg.ExecMatchers(matchers, "<think>x</think>1 <th"))
+
+	var response pkg.ChatResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if message := response.Choices[0].Message; message.Content != "1 <th" || message.ReasoningContent != "x" {
+		t.Fatalf("unexpected response: %s", recorder.Body.String())
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"net/http"
 	"strings"
 	"time"
@@ -69,13 +68,13 @@ func IsCanceled(ctx context.Context) bool {
 //	code 401 http.StatusUnauthorized
 //	err.Type ...
 func ErrResponse(ctx *gin.Context, code int, err interface{}) {
-	logrus.Errorf("response error: %v", err)
+	common.Logger(ctx).Errorf("response error: %v", err)
 	if code == -1 {
 		code = http.StatusInternalServerError
 	}
 
 	if str, ok := err.(string); ok {
-		ctx.JSON(code, gin.H{
+		writeJSON(ctx, code, gin.H{
 			"error": map[string]string{
 				"message": str,
 			},
@@ -84,7 +83,7 @@ func ErrResponse(ctx *gin.Context, code int, err interface{}) {
 	}
 
 	if e, ok := err.(error); ok {
-		ctx.JSON(code, gin.H{
+		writeJSON(ctx, code, gin.H{
 			"error": map[string]string{
 				"message": e.Error(),
 			},
@@ -92,30 +91,64 @@ func ErrResponse(ctx *gin.Context, code int, err interface{}) {
 		return
 	}
 
-	ctx.JSON(code, gin.H{
+	writeJSON(ctx, code, gin.H{
 		"error": map[string]string{
 			"message": fmt.Sprintf("%v", err),
 		},
 	})
 }
 
+// 带错误码的错误响应，如上游内容过滤时 code 为 content_filter，type 由状态码决定
+func ErrCodeResponse(ctx *gin.Context, status int, code, message string) {
+	common.Logger(ctx).Errorf("response error: %s: %s", code, message)
+	writeJSON(ctx, status, gin.H{
+		"error": map[string]string{
+			"message": message,
+			"type":    errorType(status),
+			"code":    code,
+		},
+	})
+}
+
+// OpenAI 错误类型
+func errorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
 func Response(ctx *gin.Context, model, content string) {
 	created := time.Now().Unix()
 	usage := common.GetGinCompletionUsage(ctx)
-	ctx.JSON(http.StatusOK, pkg.ChatResponse{
+	content += flushMatchers(ctx)
+	reasoning := takeReasoning(ctx)
+	annotations := common.GetGinAnnotations(ctx)
+	finishReason := FinishReason(ctx)
+	writeJSON(ctx, http.StatusOK, pkg.ChatResponse{
 		Model:   model,
 		Created: created,
-		Id:      fmt.Sprintf("chatcmpl-%d", created),
+		Id:      CompletionId(ctx, created),
 		Object:  "chat.completion",
 		Choices: []pkg.ChatChoice{
 			{
 				Index: 0,
 				Message: &struct {
-					Role      string                  `json:"role,omitempty"`
-					Content   string                  `json:"content,omitempty"`
-					ToolCalls []pkg.Keyv[interface{}] `json:"tool_calls,omitempty"`
-				}{"assistant", content, nil},
-				FinishReason: &stop,
+					Role             string                  `json:"role,omitempty"`
+					Content          string                  `json:"content,omitempty"`
+					ReasoningContent string                  `json:"reasoning_content,omitempty"`
+					ToolCalls        []pkg.Keyv[interface{}] `json:"tool_calls,omitempty"`
+					Annotations      []pkg.Keyv[interface{}] `json:"annotations,omitempty"`
+				}{"assistant", content, reasoning, nil, annotations},
+				FinishReason: &finishReason,
 			},
 		},
 		Usage: usage,
@@ -126,61 +159,67 @@ func SSEResponse(ctx *gin.Context, model, content string, created int64) {
 	setSSEHeader(ctx)
 
 	done := false
-	finishReason := ""
 	usage := common.GetGinCompletionUsage(ctx)
-
 	if content == "[DONE]" {
 		done = true
-		content = ""
-		finishReason = "stop"
+		content = flushMatchers(ctx)
 	}
+	reasoning := takeReasoning(ctx)
 
-	response := pkg.ChatResponse{
+	shapeResponse(ctx, model, content, reasoning, created, done)
+
+	if done {
+		finishReason := FinishReason(ctx)
+		response := sseChunk(ctx, model, "", "", created)
+		response.Usage = usage
+		response.Choices[0].Delta.Annotations = common.GetGinAnnotations(ctx)
+		response.Choices[0].FinishReason = &finishReason
+		event(ctx, response)
+
+		time.Sleep(100 * time.Millisecond)
+		event(ctx, "[DONE]")
+	}
+}
+
+func sseChunk(ctx *gin.Context, model, content, reasoning string, created int64) pkg.ChatResponse {
+	return pkg.ChatResponse{
 		Model:   model,
 		Created: created,
-		Id:      fmt.Sprintf("chatcmpl-%d", created),
+		Id:      CompletionId(ctx, created),
 		Object:  "chat.completion.chunk",
 		Choices: []pkg.ChatChoice{
 			{
 				Index: 0,
 				Delta: &struct {
-					Role      string                  `json:"role,omitempty"`
-					Content   string                  `json:"content,omitempty"`
-					ToolCalls []pkg.Keyv[interface{}] `json:"tool_calls,omitempty"`
-				}{"assistant", content, nil},
+					Role             string                  `json:"role,omitempty"`
+					Content          string                  `json:"content,omitempty"`
+					ReasoningContent string                  `json:"reasoning_content,omitempty"`
+					ToolCalls        []pkg.Keyv[interface{}] `json:"tool_calls,omitempty"`
+					Annotations      []pkg.Keyv[interface{}] `json:"annotations,omitempty"`
+				}{"assistant", content, reasoning, nil, nil},
 			},
 		},
 	}
-
-	if finishReason != "" {
-		response.Usage = usage
-		response.Choices[0].FinishReason = &finishReason
-	}
-
-	event(ctx, response)
-
-	if done {
-		time.Sleep(100 * time.Millisecond)
-		event(ctx, "[DONE]")
-	}
 }
 
 func ToolCallResponse(ctx *gin.Context, model, name, args string) {
 	created := time.Now().Unix()
 	usage := common.GetGinCompletionUsage(ctx)
 
-	ctx.JSON(http.StatusOK, pkg.ChatResponse{
+	writeJSON(ctx, http.StatusOK, pkg.ChatResponse{
 		Model:   model,
 		Created: created,
-		Id:      fmt.Sprintf("chatcmpl-%d", created),
+		Id:      CompletionId(ctx, created),
 		Object:  "chat.completion",
 		Choices: []pkg.ChatChoice{
 			{
 				Index: 0,
 				Message: &struct {
-					Role      string                  `json:"role,omitempty"`
-					Content   string                  `json:"content,omitempty"`
-					ToolCalls []pkg.Keyv[interface{}] `json:"tool_calls,omitempty"`
+					Role             string                  `json:"role,omitempty"`
+					Content          string                  `json:"content,omitempty"`
+					ReasoningContent string                  `json:"reasoning_content,omitempty"`
+					ToolCalls        []pkg.Keyv[interface{}] `json:"tool_calls,omitempty"`
+					Annotations      []pkg.Keyv[interface{}] `json:"annotations,omitempty"`
 				}{
 					Role: "assistant",
 					ToolCalls: []pkg.Keyv[interface{}]{
@@ -203,12 +242,13 @@ func ToolCallResponse(ctx *gin.Context, model, name, args string) {
 
 func SSEToolCallResponse(ctx *gin.Context, model, name, args string, created int64) {
 	setSSEHeader(ctx)
+	flushShaper(ctx, model, created)
 	usage := common.GetGinCompletionUsage(ctx)
 
 	response := pkg.ChatResponse{
 		Model:   model,
 		Created: created,
-		Id:      fmt.Sprintf("chatcmpl-%d", created),
+		Id:      CompletionId(ctx, created),
 		Object:  "chat.completion.chunk",
 		Choices: []pkg.ChatChoice{
 			{Index: 0},
@@ -221,9 +261,11 @@ func SSEToolCallResponse(ctx *gin.Context, model, name, args string, created int
 	toolCall["id"] = "call_" + common.RandStr(5)
 	toolCall["function"] = map[string]string{"name": name, "arguments": ""}
 	response.Choices[0].Delta = &struct {
-		Role      string                  `json:"role,omitempty"`
-		Content   string                  `json:"content,omitempty"`
-		ToolCalls []pkg.Keyv[interface{}] `json:"tool_calls,omitempty"`
+		Role             string                  `json:"role,omitempty"`
+		Content          string                  `json:"content,omitempty"`
+		ReasoningContent string                  `json:"reasoning_content,omitempty"`
+		ToolCalls        []pkg.Keyv[interface{}] `json:"tool_calls,omitempty"`
+		Annotations      []pkg.Keyv[interface{}] `json:"annotations,omitempty"`
 	}{
 		Role:      "assistant",
 		ToolCalls: []pkg.Keyv[interface{}]{toolCall},
@@ -246,6 +288,73 @@ func SSEToolCallResponse(ctx *gin.Context, model, name, args string, created int
 	event(ctx, "[DONE]")
 }
 
+// 透传上游原生的 tool_calls
+func ToolCallsResponse(ctx *gin.Context, model, content string, calls []pkg.Keyv[interface{}]) {
+	created := time.Now().Unix()
+	usage := common.GetGinCompletionUsage(ctx)
+	content += flushMatchers(ctx)
+	reasoning := takeReasoning(ctx)
+
+	writeJSON(ctx, http.StatusOK, pkg.ChatResponse{
+		Model:   model,
+		Created: created,
+		Id:      CompletionId(ctx, created),
+		Object:  "chat.completion",
+		Choices: []pkg.ChatChoice{
+			{
+				Index: 0,
+				Message: &struct {
+					Role             string                  `json:"role,omitempty"`
+					Content          string                  `json:"content,omitempty"`
+					ReasoningContent string                  `json:"reasoning_content,omitempty"`
+					ToolCalls        []pkg.Keyv[interface{}] `json:"tool_calls,omitempty"`
+					Annotations      []pkg.Keyv[interface{}] `json:"annotations,omitempty"`
+				}{"assistant", content, reasoning, calls, nil},
+				FinishReason: &toolCalls,
+			},
+		},
+		Usage: usage,
+	})
+}
+
+// 透传上游原生的流式 tool_calls 增量，calls 为 nil 时输出结束块
+func SSEToolCallsResponse(ctx *gin.Context, model string, calls []pkg.Keyv[interface{}], created int64) {
+	setSSEHeader(ctx)
+
+	response := sseChunk(ctx, model, "", "", created)
+	if calls != nil {
+		flushShaper(ctx, model, created)
+		response.Choices[0].Delta.ToolCalls = calls
+		event(ctx, response)
+		return
+	}
+
+	content := flushMatchers(ctx)
+	shapeResponse(ctx, model, content, takeReasoning(ctx), created, true)
+
+	response.Choices[0].Delta = nil
+	response.Choices[0].FinishReason = &toolCalls
+	response.Usage = common.GetGinCompletionUsage(ctx)
+	event(ctx, response)
+	event(ctx, "[DONE]")
+}
+
+// 结束原因，默认 stop；上游返回 length、content_filter 等时由适配器设置
+func FinishReason(ctx *gin.Context) string {
+	if reason := ctx.GetString(vars.GinFinishReason); reason != "" {
+		return reason
+	}
+	return stop
+}
+
+// 补全id，优先使用请求的 X-Request-Id
+func CompletionId(ctx *gin.Context, created int64) string {
+	if id := common.GetGinRequestId(ctx); id != "" {
+		return "chatcmpl-" + id
+	}
+	return fmt.Sprintf("chatcmpl-%d", created)
+}
+
 func NotSSEHeader(ctx *gin.Context) bool {
 	h := ctx.Writer.Header()
 	t := h.Get("Content-Type")
@@ -266,14 +375,35 @@ func setSSEHeader(ctx *gin.Context) {
 	}
 }
 
+func writeJSON(ctx *gin.Context, code int, data interface{}) {
+	if hook, ok := ginHook(ctx); ok {
+		hook(code, data, false)
+		return
+	}
+	AuditResponse(ctx, code, data)
+	ctx.JSON(code, data)
+}
+
 func event(ctx *gin.Context, data interface{}) {
+	if hook, ok := ginHook(ctx); ok {
+		hook(http.StatusOK, data, true)
+		return
+	}
+
+	// 客户端已断开
+	if IsCanceled(ctx.Request.Context()) {
+		ctx.Set(vars.GinClose, true)
+		return
+	}
+	AuditResponse(ctx, http.StatusOK, data)
+
 	w := ctx.Writer
 	str, ok := data.(string)
 	if ok {
 		layout := "data: %s\n\n"
 		_, err := fmt.Fprintf(w, layout, str)
 		if err != nil {
-			logrus.Error(err)
+			common.Logger(ctx).Error(err)
 			ctx.Set(vars.GinClose, true)
 			return
 		}
@@ -284,14 +414,14 @@ func event(ctx *gin.Context, data interface{}) {
 
 	marshal, err := json.Marshal(data)
 	if err != nil {
-		logrus.Error(err)
+		common.Logger(ctx).Error(err)
 		ctx.Set(vars.GinClose, true)
 		return
 	}
 
 	_, err = fmt.Fprintf(w, "data: %s\n\n", marshal)
 	if err != nil {
-		logrus.Error(err)
+		common.Logger(ctx).Error(err)
 		ctx.Set(vars.GinClose, true)
 		return
 	}
@@ -0,0 +1,166 @@
+package middle
+
+import (
+	"fmt"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	SamplingReject = "reject"
+	SamplingIgnore = "ignore"
+	SamplingWarn   = "warn"
+)
+
+// 全部可校验的采样参数
+var SamplingParams = []string{
+	"temperature",
+	"top_p",
+	"top_k",
+	"max_tokens",
+	"presence_penalty",
+	"frequency_penalty",
+	"seed",
+	"logit_bias",
+	"user",
+}
+
+// 采样参数校验：对上游不支持的参数按模型策略处理
+//
+//	reject: 返回400错误
+//	ignore: 丢弃参数
+//	warn:   丢弃参数并打印警告日志
+//
+//	supported: 上游支持的参数，nil 时不做校验
+func SamplingValidator(ctx *gin.Context, supported []string) bool {
+	if supported == nil {
+		return true
+	}
+
+	completion := common.GetGinCompletion(ctx)
+	var unsupported []string
+	for _, param := range SamplingParams {
+		if common.Contains(supported, param) {
+			continue
+		}
+		if samplingSet(completion, param) {
+			unsupported = append(unsupported, param)
+		}
+	}
+
+	if len(unsupported) == 0 {
+		return true
+	}
+
+	switch samplingPolicy(completion.Model) {
+	case SamplingReject:
+		ErrResponse(ctx, http.StatusBadRequest, fmt.Sprintf("model '%s' does not support parameters: %s", completion.Model, strings.Join(unsupported, ", ")))
+		return false
+	case SamplingWarn:
+		common.Logger(ctx).Warnf("model '%s' does not support parameters, ignored: %s", completion.Model, strings.Join(unsupported, ", "))
+	}
+
+	for _, param := range unsupported {
+		samplingUnset(&completion, param)
+	}
+	ctx.Set(vars.GinCompletion, completion)
+	return true
+}
+
+// 参数的数量超出上游限制时按模型策略处理，如 gemini 最多支持 5 个停止词
+//
+//	reject: 返回400错误
+//	ignore: 由调用方截断到 limit
+//	warn:   截断并打印警告日志
+func SamplingLimit(ctx *gin.Context, param string, count, limit int) bool {
+	if count <= limit {
+		return true
+	}
+
+	model := common.GetGinCompletion(ctx).Model
+	switch samplingPolicy(model) {
+	case SamplingReject:
+		ErrResponse(ctx, http.StatusBadRequest, fmt.Sprintf("model '%s' supports at most %d %s, got %d", model, limit, param, count))
+		return false
+	case SamplingWarn:
+		common.Logger(ctx).Warnf("model '%s' supports at most %d %s, truncated from %d", model, limit, param, count)
+	}
+	return true
+}
+
+// 按模型匹配策略，支持通配符，如 lmsys/*
+func samplingPolicy(model string) string {
+	policy := pkg.Config.GetString("sampling.policy")
+	if policy == "" {
+		policy = SamplingWarn
+	}
+
+	models, _ := pkg.Config.Get("sampling.models").([]interface{})
+	for _, it := range models {
+		item, ok := it.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		pattern, _ := item["model"].(string)
+		if matched, _ := path.Match(pattern, model); matched {
+			if value, o := item["policy"].(string); o {
+				return value
+			}
+		}
+	}
+	return policy
+}
+
+func samplingSet(completion pkg.ChatCompletion, param string) bool {
+	switch param {
+	case "temperature":
+		return completion.Temperature != 0
+	case "top_p":
+		return completion.TopP != 0
+	case "top_k":
+		return completion.TopK != 0
+	case "max_tokens":
+		return completion.MaxTokens != 0
+	case "presence_penalty":
+		return completion.PresencePenalty != 0
+	case "frequency_penalty":
+		return completion.FrequencyPenalty != 0
+	case "seed":
+		return completion.Seed != nil
+	case "logit_bias":
+		return len(completion.LogitBias) > 0
+	case "user":
+		return completion.User != ""
+	default:
+		return false
+	}
+}
+
+func samplingUnset(completion *pkg.ChatCompletion, param string) {
+	switch param {
+	case "temperature":
+		completion.Temperature = 0
+	case "top_p":
+		completion.TopP = 0
+	case "top_k":
+		completion.TopK = 0
+	case "max_tokens":
+		completion.MaxTokens = 0
+	case "presence_penalty":
+		completion.PresencePenalty = 0
+	case "frequency_penalty":
+		completion.FrequencyPenalty = 0
+	case "seed":
+		completion.Seed = nil
+	case "logit_bias":
+		completion.LogitBias = nil
+	case "user":
+		completion.User = ""
+	}
+}
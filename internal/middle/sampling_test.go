@@ -0,0 +1,79 @@
+package middle
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSamplingPolicy(t *testing.T) {
+	config := testutil.Config(t)
+	if policy := samplingPolicy("bing"); policy != SamplingWarn {
+		t.Fatalf("unexpected default policy: %s", policy)
+	}
+
+	config.Set("sampling.policy", SamplingReject)
+	config.Set("sampling.models", []interface{}{
+		map[string]interface{}{"model": "lmsys/*", "policy": SamplingIgnore},
+		map[string]interface{}{"model": "gemini-1.?-*", "policy": SamplingWarn},
+		map[string]interface{}{"model": "coze"},
+	})
+
+	for model, expected := range map[string]string{
+		"lmsys/claude-3-haiku":  SamplingIgnore,
+		"lmsys":                 SamplingReject,
+		"lmsys/a/b":             SamplingReject, // * 不匹配 /
+		"gemini-1.5-pro-latest": SamplingWarn,
+		"gemini-2.0-pro":        SamplingReject,
+		"coze":                  SamplingReject, // 未设置 policy 时使用默认值
+		"bing":                  SamplingReject,
+	} {
+		if policy := samplingPolicy(model); policy != expected {
+			t.Errorf("%s: expected %s, got %s", model, expected, policy)
+		}
+	}
+}
+
+func TestSamplingValidator(t *testing.T) {
+	config := testutil.Config(t)
+	seed := 1
+	completion := pkg.ChatCompletion{Model: "gemini-1.5-pro-latest", Temperature: 0.5, MaxTokens: 10, Seed: &seed, User: "u"}
+
+	// warn: 丢弃不支持的参数
+	ctx, _ := testutil.Completion(completion)
+	if !SamplingValidator(ctx, []string{"temperature", "top_p", "top_k"}) {
+		t.Fatal("unexpected reject")
+	}
+	if value := common.GetGinCompletion(ctx); value.Temperature != 0.5 || value.MaxTokens != 0 || value.Seed != nil || value.User != "" {
+		t.Fatalf("unexpected completion: %+v", value)
+	}
+
+	// reject: 400 并列出参数
+	config.Set("sampling.policy", SamplingReject)
+	ctx, recorder := testutil.Completion(completion)
+	if SamplingValidator(ctx, []string{"temperature", "max_tokens", "seed"}) || recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "user") || strings.Contains(body, "seed") {
+		t.Fatalf("unexpected error: %s", body)
+	}
+}
+
+func TestSamplingLimit(t *testing.T) {
+	config := testutil.Config(t)
+	completion := pkg.ChatCompletion{Model: "gemini-1.5-pro-latest"}
+
+	ctx, recorder := testutil.Completion(completion)
+	if !SamplingLimit(ctx, "stop", 5, 5) || !SamplingLimit(ctx, "stop", 6, 5) || recorder.Body.Len() > 0 {
+		t.Fatalf("unexpected response: %s", recorder.Body.String())
+	}
+
+	config.Set("sampling.policy", SamplingReject)
+	ctx, recorder = testutil.Completion(completion)
+	if SamplingLimit(ctx, "stop", 6, 5) || recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
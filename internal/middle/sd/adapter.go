@@ -11,7 +11,6 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/bincooo/emit.io"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"io"
 	"math/rand"
 	"net/http"
@@ -277,7 +276,7 @@ func (API) Generation(ctx *gin.Context) {
 	if (generation.Size == "HD" || strings.HasPrefix(generation.Size, "1792x")) && com.HasMfy() {
 		v, e := com.Magnify(ctx, value)
 		if e != nil {
-			logrus.Error(e)
+			com.Logger(ctx).Error(e)
 		} else {
 			value = v
 		}
@@ -365,13 +364,13 @@ func completeTagsGenerator(ctx *gin.Context, content string) (string, error) {
 
 	if left > -1 && left < right {
 		message = strings.ReplaceAll(message[left+3:right], "\"", "")
-		logrus.Infof("system assistant generate prompt[%s]: %s", model, message)
+		com.Logger(ctx).Infof("system assistant generate prompt[%s]: %s", model, message)
 		return strings.TrimSpace(message), nil
 	}
 
 	if strings.HasSuffix(message, `"""`) { // 哎。bing 偶尔会漏掉前面的"""
 		message = strings.ReplaceAll(message[:len(message)-3], "\"", "")
-		logrus.Infof("system assistant generate prompt[%s]: %s", model, message)
+		com.Logger(ctx).Infof("system assistant generate prompt[%s]: %s", model, message)
 		return strings.TrimSpace(message), nil
 	}
 
@@ -380,12 +379,12 @@ func completeTagsGenerator(ctx *gin.Context, content string) (string, error) {
 
 	if left > -1 && left < right {
 		message = strings.ReplaceAll(message[left+3:right], "\"", "")
-		logrus.Infof("system assistant generate prompt[%s]: %s", model, message)
+		com.Logger(ctx).Infof("system assistant generate prompt[%s]: %s", model, message)
 		return strings.TrimSpace(message), nil
 	}
 
-	logrus.Info("response content: ", message)
-	logrus.Errorf("system assistant generate prompt[%s] error: system assistant generate prompt failed", model)
+	com.Logger(ctx).Info("response content: ", message)
+	com.Logger(ctx).Errorf("system assistant generate prompt[%s] error: system assistant generate prompt failed", model)
 	return "", errors.New("system assistant generate prompt failed")
 }
 
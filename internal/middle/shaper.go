@@ -0,0 +1,193 @@
+package middle
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"sync"
+	"time"
+)
+
+// 流式输出整形：合并细碎的增量，拆分大块的突发输出
+type shaper struct {
+	sync.Mutex
+	content   []rune
+	reasoning []rune
+	last      time.Time
+	timer     *time.Timer
+	done      bool
+	closed    bool // 补全已结束，不再输出
+	model     string
+	created   int64
+	// 由上层上下文负责整形，例如续写时子上下文的输出会再经过父上下文的 SSEResponse
+	skip bool
+}
+
+type shapedChunk struct {
+	content   string
+	reasoning string
+}
+
+// 子上下文的输出由上层重新整形时调用，避免重复缓存
+func skipShaper(ctx *gin.Context) {
+	ctx.Set(vars.GinShaper, &shaper{skip: true})
+}
+
+// 整形后输出流式块，未开启整形时原样输出
+//
+//	shaper.interval: 合并的最小间隔（毫秒），距上次输出未到间隔时继续缓存，
+//	                 缓存的内容在间隔后没有新的增量时由定时器输出
+//	shaper.min_size: 合并的最小字符数，缓存达到该长度时立即输出
+//	shaper.max_size: 拆分的最大字符数，超出时按该长度拆分，0 不拆分
+func shapeResponse(ctx *gin.Context, model, content, reasoning string, created int64, done bool) {
+	s, ok := common.GetGinValue[*shaper](ctx, vars.GinShaper)
+	if (ok && s.skip) || !pkg.Config.GetBool("shaper.enabled") {
+		if content != "" || reasoning != "" {
+			event(ctx, sseChunk(ctx, model, content, reasoning, created))
+		}
+		return
+	}
+
+	if !ok {
+		s = &shaper{}
+		ctx.Set(vars.GinShaper, s)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	if s.closed {
+		return
+	}
+
+	s.model, s.created = model, created
+	s.content = append(s.content, []rune(content)...)
+	s.reasoning = append(s.reasoning, []rune(reasoning)...)
+	s.emit(ctx, model, created, s.shape(done))
+	s.done = done
+	if done || len(s.content)+len(s.reasoning) == 0 {
+		return
+	}
+
+	// 没有新的增量时，到达间隔后输出缓存的结尾
+	request := ctx.Request.Context()
+	s.timer = time.AfterFunc(shapeInterval(), func() {
+		s.Lock()
+		defer s.Unlock()
+		if s.done || s.closed || IsCanceled(request) || ctx.GetBool(vars.GinClose) {
+			return
+		}
+		s.emit(ctx, model, created, s.shape(true))
+	})
+}
+
+// 输出整形缓存的全部内容，用于工具调用等不经过整形的输出之前
+func flushShaper(ctx *gin.Context, model string, created int64) {
+	s, ok := common.GetGinValue[*shaper](ctx, vars.GinShaper)
+	if !ok || s.skip {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.emit(ctx, model, created, s.shape(true))
+}
+
+// 补全结束时调用，不依赖适配器输出 [DONE]：停止定时器，
+// 仍在输出流式响应时输出缓存的结尾，已返回错误或客户端已断开时丢弃
+func CloseShaper(ctx *gin.Context) {
+	s, ok := common.GetGinValue[*shaper](ctx, vars.GinShaper)
+	if !ok || s.skip {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if !s.closed && !NotSSEHeader(ctx) && !IsClosed(ctx) {
+		s.emit(ctx, s.model, s.created, s.shape(true))
+	}
+	s.content = nil
+	s.reasoning = nil
+	s.closed = true
+}
+
+// 取出待输出的块，flush 为 true 时输出全部缓存
+func (s *shaper) shape(flush bool) []shapedChunk {
+	size := len(s.content) + len(s.reasoning)
+	if size == 0 {
+		return nil
+	}
+
+	if !flush && size < pkg.Config.GetInt("shaper.min_size") && time.Since(s.last) < shapeInterval() {
+		return nil
+	}
+
+	maxSize := pkg.Config.GetInt("shaper.max_size")
+	var chunks []shapedChunk
+	for _, value := range splitRunes(s.reasoning, maxSize) {
+		chunks = append(chunks, shapedChunk{reasoning: value})
+	}
+	values := splitRunes(s.content, maxSize)
+	s.content = nil
+	s.reasoning = nil
+
+	// 拆分后不足一块的结尾留到下次输出，保持块大小均匀
+	if count := len(values); !flush && count > 1 && len([]rune(values[count-1])) < maxSize {
+		s.content = []rune(values[count-1])
+		values = values[:count-1]
+	}
+
+	for _, value := range values {
+		chunks = append(chunks, shapedChunk{content: value})
+	}
+
+	s.last = time.Now()
+	return chunks
+}
+
+func (s *shaper) emit(ctx *gin.Context, model string, created int64, chunks []shapedChunk) {
+	for index, chunk := range chunks {
+		if index > 0 {
+			time.Sleep(shapePace())
+		}
+		event(ctx, sseChunk(ctx, model, chunk.content, chunk.reasoning, created))
+	}
+}
+
+func shapeInterval() time.Duration {
+	return time.Duration(pkg.Config.GetInt("shaper.interval")) * time.Millisecond
+}
+
+// 拆分后每块之间的输出间隔
+func shapePace() time.Duration {
+	return time.Duration(pkg.Config.GetInt("shaper.pace")) * time.Millisecond
+}
+
+func splitRunes(value []rune, size int) (slice []string) {
+	if size <= 0 {
+		size = len(value)
+	}
+
+	for len(value) > 0 {
+		pos := size
+		if pos > len(value) {
+			pos = len(value)
+		}
+		slice = append(slice, string(value[:pos]))
+		value = value[pos:]
+	}
+	return
+}
@@ -0,0 +1,138 @@
+package middle
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/common"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func shaperConfig(t *testing.T, interval, minSize, maxSize int) {
+	config := testutil.Config(t)
+	config.Set("shaper.enabled", true)
+	config.Set("shaper.interval", interval)
+	config.Set("shaper.min_size", minSize)
+	config.Set("shaper.max_size", maxSize)
+}
+
+func chunkContents(responses []pkg.ChatResponse) (contents []string) {
+	for _, response := range responses {
+		if delta := response.Choices[0].Delta; delta != nil && delta.Content != "" {
+			contents = append(contents, delta.Content)
+		}
+	}
+	return
+}
+
+func TestShapeChunks(t *testing.T) {
+	shaperConfig(t, 1000, 8, 4)
+	ctx, recorder := testutil.Completion(pkg.ChatCompletion{Stream: true})
+	created := time.Now().Unix()
+
+	// 首个增量立即输出，之后的细碎增量合并，超出 max_size 时拆分并保留结尾
+	SSEResponse(ctx, "mock", "a", created)
+	SSEResponse(ctx, "mock", "bc", created)
+	SSEResponse(ctx, "mock", "de", created)
+	SSEResponse(ctx, "mock", "fghij", created)
+	SSEResponse(ctx, "mock", "[DONE]", created)
+
+	responses, done := sseEvents(t, recorder.Body.String())
+	expected := []string{"a", "bcde", "fghi", "j"}
+	if contents := chunkContents(responses); !reflect.DeepEqual(contents, expected) || done != 1 {
+		t.Fatalf("unexpected chunks: %q", contents)
+	}
+}
+
+func TestShapeTimerFlush(t *testing.T) {
+	shaperConfig(t, 20, 100, 0)
+	ctx, recorder := testutil.Completion(pkg.ChatCompletion{Stream: true})
+	created := time.Now().Unix()
+
+	SSEResponse(ctx, "mock", "a", created)
+	SSEResponse(ctx, "mock", "bc", created)
+
+	// 没有新的增量，间隔后由定时器输出缓存
+	time.Sleep(100 * time.Millisecond)
+	s, _ := common.GetGinValue[*shaper](ctx, vars.GinShaper)
+	s.Lock()
+	responses, done := sseEvents(t, recorder.Body.String())
+	s.Unlock()
+
+	if contents := chunkContents(responses); !reflect.DeepEqual(contents, []string{"a", "bc"}) || done != 0 {
+		t.Fatalf("unexpected chunks: %q", contents)
+	}
+
+	SSEResponse(ctx, "mock", "[DONE]", created)
+	responses, done = sseEvents(t, recorder.Body.String())
+	if contents := chunkContents(responses); len(contents) != 2 || done != 1 {
+		t.Fatalf("unexpected chunks: %q", contents)
+	}
+}
+
+func TestShapeFork(t *testing.T) {
+	shaperConfig(t, 1000, 8, 4)
+	ctx, _ := testutil.Completion(pkg.ChatCompletion{Stream: true})
+	created := time.Now().Unix()
+	SSEResponse(ctx, "mock", "a", created)
+	SSEResponse(ctx, "mock", "b", created)
+
+	var (
+		mu       sync.Mutex
+		contents []string
+	)
+	child, cancel := Fork(ctx, func(code int, data interface{}, sse bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if response, ok := data.(pkg.ChatResponse); ok {
+			contents = append(contents, chunkContents([]pkg.ChatResponse{response})...)
+		}
+	})
+	defer cancel()
+
+	// 子上下文独立整形，不混入父上下文缓存的内容
+	SSEResponse(child, "mock", "abcdefghij", created)
+	SSEResponse(child, "mock", "[DONE]", created)
+
+	SSEResponse(ctx, "mock", "[DONE]", created)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if expected := []string{"abcd", "efgh", "ij"}; !reflect.DeepEqual(contents, expected) {
+		t.Fatalf("unexpected chunks: %q", contents)
+	}
+}
+
+func TestShapeClose(t *testing.T) {
+	shaperConfig(t, 20, 100, 0)
+	created := time.Now().Unix()
+
+	// 适配器未输出 [DONE] 即返回：输出缓存的结尾，之后定时器不再输出
+	ctx, recorder := testutil.Completion(pkg.ChatCompletion{Stream: true})
+	SSEResponse(ctx, "mock", "a", created)
+	SSEResponse(ctx, "mock", "bc", created)
+	CloseShaper(ctx)
+	SSEResponse(ctx, "mock", "d", created)
+	time.Sleep(60 * time.Millisecond)
+
+	responses, done := sseEvents(t, recorder.Body.String())
+	if contents := chunkContents(responses); !reflect.DeepEqual(contents, []string{"a", "bc"}) || done != 0 {
+		t.Fatalf("unexpected chunks: %q", contents)
+	}
+
+	// 客户端已断开时丢弃缓存
+	ctx, recorder = testutil.Completion(pkg.ChatCompletion{Stream: true})
+	SSEResponse(ctx, "mock", "a", created)
+	SSEResponse(ctx, "mock", "bc", created)
+	ctx.Set(vars.GinClose, true)
+	CloseShaper(ctx)
+	time.Sleep(60 * time.Millisecond)
+
+	responses, _ = sseEvents(t, recorder.Body.String())
+	if contents := chunkContents(responses); !reflect.DeepEqual(contents, []string{"a"}) {
+		t.Fatalf("unexpected chunks: %q", contents)
+	}
+}
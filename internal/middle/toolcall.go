@@ -58,7 +58,7 @@ func CompleteToolCalls(ctx *gin.Context, completion pkg.ChatCompletion, callback
 	if err != nil {
 		return false, err
 	}
-	logrus.Infof("completeTools response: \n%s", content)
+	common.Logger(ctx).Infof("completeTools response: \n%s", content)
 
 	previousTokens := common.CalcTokens(message)
 	ctx.Set(vars.GinCompletionUsage, common.CalcUsageTokens(content, previousTokens))
@@ -79,7 +79,7 @@ func completeToolTasks(ctx *gin.Context, completion pkg.ChatCompletion, callback
 	if err != nil {
 		return
 	}
-	logrus.Infof("completeTasks response: \n%s", content)
+	common.Logger(ctx).Infof("completeTasks response: \n%s", content)
 
 	// 解析参数
 	tasks := parseToToolTasks(content, completion)
@@ -240,7 +240,7 @@ func parseToToolCall(ctx *gin.Context, content string, completion pkg.ChatComple
 	// 解析参数
 	var js map[string]interface{}
 	if err := json.Unmarshal([]byte(j), &js); err != nil {
-		logrus.Error(err)
+		common.Logger(ctx).Error(err)
 		if valueDef != "-1" {
 			return toolCallResponse(ctx, completion, valueDef, "{}", created)
 		}
@@ -2,20 +2,22 @@ package middle
 
 import (
 	"github.com/bincooo/chatgpt-adapter/v2/internal/agent"
+	"github.com/bincooo/chatgpt-adapter/v2/internal/testutil"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"testing"
 )
 
 func TestToolCall(t *testing.T) {
-	message, err := buildTemplate([]pkg.Keyv[interface{}]{
+	ctx, _ := testutil.Completion(pkg.ChatCompletion{})
+	message, err := buildTemplate(ctx, pkg.ChatCompletion{Tools: []pkg.Keyv[interface{}]{
 		{
 			"type": "function",
-			"function": pkg.Keyv[interface{}]{
+			"function": map[string]interface{}{
 				"name":        "drawing",
 				"url":         "https://web-crawler.chat-plugin.lobehub.com/api/v1",
 				"description": "根据用户要求进行画图。",
-				"parameters": pkg.Keyv[interface{}]{
-					"required": []string{"url"},
+				"parameters": map[string]interface{}{
+					"required": []interface{}{"url"},
 					"properties": map[string]interface{}{
 						"description": map[string]string{
 							"description": "{description} is: {sceneDetailed}%20{adjective}%20{charactersDetailed}%20{visualStyle}%20{genre}%20{artistReference}\n\nMake sure the prompts in the URL are encoded. Don't quote the generated markdown or put any code box around it.\nNeed to use English.",
@@ -29,7 +31,7 @@ func TestToolCall(t *testing.T) {
 				},
 			},
 		},
-	}, []pkg.Keyv[interface{}]{
+	}, Messages: []pkg.Keyv[interface{}]{
 		{
 			"content": "你好",
 			"role":    "user",
@@ -42,7 +44,7 @@ func TestToolCall(t *testing.T) {
 			"content": "画一只小猪",
 			"role":    "user",
 		},
-	}, agent.ToolCall, 3)
+	}}, agent.ToolCall)
 	if err != nil {
 		t.Fatal(err)
 	}
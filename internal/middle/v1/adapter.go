@@ -10,7 +10,6 @@ import (
 	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
 	"github.com/bincooo/chatgpt-adapter/v2/pkg"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"net/http"
 	"strings"
 	"time"
@@ -29,6 +28,10 @@ func (API) Match(_ *gin.Context, model string) bool {
 	return Model == model
 }
 
+func (API) Sampling(*gin.Context, string) []string {
+	return []string{}
+}
+
 func (API) Models() []middle.Model {
 	return []middle.Model{
 		{
@@ -145,6 +148,10 @@ func waitResponse(ctx *gin.Context, response *http.Response, matchers []pkg.Matc
 
 	for {
 		if !scanner.Scan() {
+			if middle.IsClosed(ctx) {
+				middle.Abandon[string](ctx, nil)
+				return
+			}
 			break
 		}
 
@@ -164,7 +171,7 @@ func waitResponse(ctx *gin.Context, response *http.Response, matchers []pkg.Matc
 		}
 
 		if r.Error != nil {
-			logrus.Errorf("%v", r.Error)
+			common.Logger(ctx).Errorf("%v", r.Error)
 			return
 		}
 
@@ -182,7 +189,7 @@ func waitResponse(ctx *gin.Context, response *http.Response, matchers []pkg.Matc
 		}
 		content = raw
 		raw = raw[pos:]
-		fmt.Printf("----- raw -----\n %s\n", raw)
+		common.LogRaw(ctx, raw)
 		raw = pkg.ExecMatchers(matchers, raw)
 
 		if sse {
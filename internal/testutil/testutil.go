@@ -0,0 +1,35 @@
+// 测试用的公共方法
+package testutil
+
+import (
+	"github.com/bincooo/chatgpt-adapter/v2/internal/vars"
+	"github.com/bincooo/chatgpt-adapter/v2/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 将全局配置替换为空配置，测试结束后还原
+func Config(t testing.TB) *viper.Viper {
+	config := pkg.Config
+	pkg.Config = viper.New()
+	t.Cleanup(func() { pkg.Config = config })
+	return pkg.Config
+}
+
+// 模拟 /v1/chat/completions 请求的上下文，completion 写入 vars.GinCompletion
+func Completion(completion pkg.ChatCompletion) (*gin.Context, *httptest.ResponseRecorder) {
+	ctx, recorder := Context(http.MethodPost, "/v1/chat/completions")
+	ctx.Set(vars.GinCompletion, completion)
+	return ctx, recorder
+}
+
+// 模拟请求的上下文
+func Context(method, path string) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(method, path, nil)
+	return ctx, recorder
+}
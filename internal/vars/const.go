@@ -10,4 +10,11 @@ const (
 	GinMatchers        = "__matchers__"
 	GinCompletionUsage = "__completion-usage__"
 	GinClose           = "__close__"
+	GinHook            = "__hook__"
+	GinReasoning       = "__reasoning__"
+	GinShaper          = "__shaper__"
+	GinRequestId       = "__request-id__"
+	GinAudit           = "__audit__"
+	GinFinishReason    = "__finish-reason__"
+	GinAnnotations     = "__annotations__"
 )
@@ -23,6 +23,11 @@ type Matcher interface {
 	match(content string) (state int, result string)
 }
 
+// 输出结束时需要清空缓存的匹配器
+type flusher interface {
+	flush() string
+}
+
 // 字符块匹配器，只向后匹配
 type SymbolMatcher struct {
 	cache string // 缓存的字符
@@ -31,6 +36,15 @@ type SymbolMatcher struct {
 	H func(index int, content string) (state int, result string)
 }
 
+// 思考块匹配器，将 <think>...</think> 等思考块从正文中分离
+type ReasoningMatcher struct {
+	cache string // 缓存的字符
+	close string // 当前所处思考块的结束标签，为空则不在思考块中
+	Tags  []string
+	// 分离出的思考内容，为nil则丢弃
+	H func(reasoning string)
+}
+
 func NewMatchers() []Matcher {
 	slice := make([]Matcher, 0)
 	// todo 内置一些过滤器
@@ -90,6 +104,20 @@ func ExecMatchers(matchers []Matcher, raw string) string {
 	return raw
 }
 
+// 输出结束时清空匹配器中缓存的尾部，依次经过其后的匹配器，返回需要追加输出的内容
+func FlushMatchers(matchers []Matcher) (raw string) {
+	for index, mat := range matchers {
+		f, ok := mat.(flusher)
+		if !ok {
+			continue
+		}
+		if tail := f.flush(); tail != "" {
+			raw += ExecMatchers(matchers[index+1:], tail)
+		}
+	}
+	return
+}
+
 func (mat *SymbolMatcher) match(content string) (state int, result string) {
 	content = mat.cache + content
 	state = vars.MatDefault
@@ -167,3 +195,78 @@ state:
 
 	return
 }
+
+func (mat *ReasoningMatcher) match(content string) (state int, result string) {
+	content = mat.cache + content
+	mat.cache = ""
+
+	var reasoning string
+	for len(content) > 0 {
+		if mat.close == "" {
+			index, tag := -1, ""
+			for _, t := range mat.Tags {
+				if i := strings.Index(content, "<"+t+">"); i >= 0 && (index == -1 || i < index) {
+					index, tag = i, t
+				}
+			}
+
+			if index >= 0 {
+				result += content[:index]
+				content = content[index+len(tag)+2:]
+				mat.close = "</" + tag + ">"
+				continue
+			}
+
+			// 尾部可能是不完整的标签，缓存等待下一次匹配
+			pos := 0
+			for _, t := range mat.Tags {
+				pos = max(pos, suffixPrefix(content, "<"+t+">"))
+			}
+			result += content[:len(content)-pos]
+			mat.cache = content[len(content)-pos:]
+			break
+		}
+
+		if index := strings.Index(content, mat.close); index >= 0 {
+			reasoning += content[:index]
+			content = content[index+len(mat.close):]
+			mat.close = ""
+			continue
+		}
+
+		pos := suffixPrefix(content, mat.close)
+		reasoning += content[:len(content)-pos]
+		mat.cache = content[len(content)-pos:]
+		break
+	}
+
+	if reasoning != "" && mat.H != nil {
+		mat.H(reasoning)
+	}
+	return vars.MatDefault, result
+}
+
+// 未闭合思考块的剩余内容作为思考内容，不完整的开始标签原样作为正文返回
+func (mat *ReasoningMatcher) flush() (result string) {
+	cache := mat.cache
+	mat.cache = ""
+	if mat.close == "" {
+		return cache
+	}
+
+	mat.close = ""
+	if cache != "" && mat.H != nil {
+		mat.H(cache)
+	}
+	return
+}
+
+// content 尾部与 s 前缀重合的最大长度
+func suffixPrefix(content, s string) int {
+	for l := min(len(content), len(s)-1); l > 0; l-- {
+		if strings.HasSuffix(content, s[:l]) {
+			return l
+		}
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func TestReasoningMatcher(t *testing.T) {
+	reasoning := ""
+	matchers := []Matcher{
+		&ReasoningMatcher{
+			Tags: []string{"think", "thinking"},
+			H: func(value string) {
+				reasoning += value
+			},
+		},
+	}
+
+	content := ""
+	for _, chunk := range []string{"<th", "ink>1 + 1", " = 2</thi", "nk>答案", "是 2 <thinking>ok</thinking>。"} {
+		content += ExecMatchers(matchers, chunk)
+	}
+
+	if content != "答案是 2 。" {
+		t.Fatalf("content: %s", content)
+	}
+
+	if reasoning != "1 + 1 = 2ok" {
+		t.Fatalf("reasoning: %s", reasoning)
+	}
+}
+
+func TestReasoningMatcherFlush(t *testing.T) {
+	reasoning := ""
+	matchers := []Matcher{
+		&ReasoningMatcher{
+			Tags: []string{"think"},
+			H: func(value string) {
+				reasoning += value
+			},
+		},
+	}
+
+	// 不完整的开始标签
+	content := ExecMatchers(matchers, "1 < 2 <th")
+	content += FlushMatchers(matchers)
+	if content != "1 < 2 <th" {
+		t.Fatalf("content: %s", content)
+	}
+
+	// 未闭合的思考块，尾部是不完整的结束标签
+	content = ExecMatchers(matchers, "<think>a < b </th")
+	content += FlushMatchers(matchers)
+	if content != "" || reasoning != "a < b </th" {
+		t.Fatalf("content: %s, reasoning: %s", content, reasoning)
+	}
+
+	// 清空后恢复正常匹配
+	if content = ExecMatchers(matchers, "ok") + FlushMatchers(matchers); content != "ok" {
+		t.Fatalf("content: %s", content)
+	}
+}
@@ -1,6 +1,9 @@
 package pkg
 
-import "reflect"
+import (
+	"encoding/json"
+	"reflect"
+)
 
 type ChatCompletion struct {
 	Messages      []Keyv[interface{}] `json:"messages"`
@@ -9,10 +12,60 @@ type ChatCompletion struct {
 	MaxTokens     int                 `json:"max_tokens"`
 	StopSequences []string            `json:"stop_sequences"`
 	Temperature   float32             `json:"temperature"`
-	TopK          int                 `json:"topK"`
-	TopP          float32             `json:"topP"`
+	TopK          int                 `json:"top_k"`
+	TopP          float32             `json:"top_p"`
 	Stream        bool                `json:"stream"`
 	ToolChoice    string              `json:"tool_choice"`
+	N             int                 `json:"n"`
+
+	PresencePenalty  float32            `json:"presence_penalty"`
+	FrequencyPenalty float32            `json:"frequency_penalty"`
+	Seed             *int               `json:"seed,omitempty"`
+	LogitBias        map[string]float32 `json:"logit_bias,omitempty"`
+	User             string             `json:"user,omitempty"`
+
+	ResponseFormat Keyv[interface{}] `json:"response_format"`
+
+	// 扩展字段：cohere 的 RAG 文档，格式同 cohere 的 documents
+	Documents []Keyv[interface{}] `json:"documents,omitempty"`
+}
+
+// 兼容旧版本的 topK、topP 参数，以及 OpenAI 的 stop（字符串或数组）
+func (c *ChatCompletion) UnmarshalJSON(data []byte) error {
+	type completion ChatCompletion
+	var value struct {
+		completion
+		LegacyTopK int         `json:"topK"`
+		LegacyTopP float32     `json:"topP"`
+		Stop       interface{} `json:"stop"`
+	}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	*c = ChatCompletion(value.completion)
+	if c.TopK == 0 {
+		c.TopK = value.LegacyTopK
+	}
+	if c.TopP == 0 {
+		c.TopP = value.LegacyTopP
+	}
+	if len(c.StopSequences) == 0 {
+		switch stop := value.Stop.(type) {
+		case string:
+			if stop != "" {
+				c.StopSequences = []string{stop}
+			}
+		case []interface{}:
+			for _, v := range stop {
+				if str, ok := v.(string); ok && str != "" {
+					c.StopSequences = append(c.StopSequences, str)
+				}
+			}
+		}
+	}
+	return nil
 }
 
 type ChatGeneration struct {
@@ -41,16 +94,20 @@ type ChatResponse struct {
 type ChatChoice struct {
 	Index   int `json:"index"`
 	Message *struct {
-		Role    string `json:"role,omitempty"`
-		Content string `json:"content,omitempty"`
+		Role             string `json:"role,omitempty"`
+		Content          string `json:"content,omitempty"`
+		ReasoningContent string `json:"reasoning_content,omitempty"`
 
-		ToolCalls []Keyv[interface{}] `json:"tool_calls,omitempty"`
+		ToolCalls   []Keyv[interface{}] `json:"tool_calls,omitempty"`
+		Annotations []Keyv[interface{}] `json:"annotations,omitempty"`
 	} `json:"message,omitempty"`
 	Delta *struct {
-		Role    string `json:"role,omitempty"`
-		Content string `json:"content,omitempty"`
+		Role             string `json:"role,omitempty"`
+		Content          string `json:"content,omitempty"`
+		ReasoningContent string `json:"reasoning_content,omitempty"`
 
-		ToolCalls []Keyv[interface{}] `json:"tool_calls,omitempty"`
+		ToolCalls   []Keyv[interface{}] `json:"tool_calls,omitempty"`
+		Annotations []Keyv[interface{}] `json:"annotations,omitempty"`
 	} `json:"delta,omitempty"`
 	FinishReason *string `json:"finish_reason"`
 }